@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/turtletowerz/crunchyrip/crunchyroll"
+	"github.com/turtletowerz/crunchyrip/hls"
+)
+
+// episodeRange is an inclusive range of episode positions, where a last of 0
+// has no end.
+type episodeRange struct {
+	first, last int
+}
+
+// episodeRanges selects episodes by their position in the series, starting at
+// 1. It is parsed from a list like "1-3,5,10-".
+type episodeRanges []episodeRange
+
+func parseEpisodeRanges(s string) (episodeRanges, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var ranges episodeRanges
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 1 {
+			return nil, fmt.Errorf("invalid episode range %q", part)
+		}
+
+		r := episodeRange{first, first}
+		if len(bounds) == 2 {
+			r.last = 0
+			if bounds[1] != "" {
+				if r.last, err = strconv.Atoi(bounds[1]); err != nil || r.last < first {
+					return nil, fmt.Errorf("invalid episode range %q", part)
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func (e episodeRanges) String() string {
+	parts := make([]string, len(e))
+	for i, r := range e {
+		switch r.last {
+		case r.first:
+			parts[i] = strconv.Itoa(r.first)
+		case 0:
+			parts[i] = strconv.Itoa(r.first) + "-"
+		default:
+			parts[i] = strconv.Itoa(r.first) + "-" + strconv.Itoa(r.last)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// contains reports whether the episode at position n is selected. No ranges
+// select every episode.
+func (e episodeRanges) contains(n int) bool {
+	if len(e) == 0 {
+		return true
+	}
+
+	for _, r := range e {
+		if n >= r.first && (r.last == 0 || n <= r.last) {
+			return true
+		}
+	}
+	return false
+}
+
+// batchEntry is a url to download, from the arguments or a line of a batch
+// file, along with the flags that apply to it.
+type batchEntry struct {
+	url   string
+	flags downloadFlags
+}
+
+// isURL reports whether arg is a Crunchyroll url rather than a username or
+// password.
+func isURL(arg string) bool {
+	_, err := crunchyroll.IsSeries(arg)
+	return err == nil
+}
+
+// entryFlags parses the overrides that follow the url of a batch line, such as
+// "quality=1080 subs=es-MX episodes=1-3", on top of the command's flags.
+func entryFlags(f downloadFlags, args []string) (downloadFlags, error) {
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&f.quality, "quality", f.quality, "")
+	fs.StringVar(&f.quality, "q", f.quality, "")
+	fs.StringVar(&f.subs, "subs", f.subs, "")
+	fs.StringVar(&f.subs, "s", f.subs, "")
+	fs.BoolVar(&f.dub, "dub", f.dub, "")
+	fs.StringVar(&f.episodes, "episodes", f.episodes, "")
+	fs.StringVar(&f.output, "output", f.output, "")
+
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "-") == false {
+			args[i] = "-" + arg
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return f, err
+	}
+
+	if fs.NArg() > 0 {
+		return f, fmt.Errorf("unexpected %q", fs.Arg(0))
+	}
+
+	if _, err := parseEpisodeRanges(f.episodes); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// readBatch reads a batch file, where each line is a url optionally followed
+// by overrides for the flags in f. Blank lines and lines starting with # are
+// skipped.
+func readBatch(path string, f downloadFlags) ([]batchEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening batch file: %w", err)
+	}
+	defer file.Close()
+
+	var entries []batchEntry
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if isURL(fields[0]) == false {
+			return nil, fmt.Errorf("%s line %d: invalid crunchyroll url %q", path, line, fields[0])
+		}
+
+		flags, err := entryFlags(f, fields[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		entries = append(entries, batchEntry{url: fields[0], flags: flags})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading batch file: %w", err)
+	}
+	return entries, nil
+}
+
+// downloadBatch downloads every entry under the same session. It stops early if
+// ctx is cancelled.
+func downloadBatch(ctx context.Context, session *crunchyroll.Session, entries []batchEntry, base downloadOptions) error {
+	if err := hls.CheckFFmpeg(); err != nil && base.dryRun == false {
+		return err
+	}
+
+	failed := 0
+	codes := map[int]bool{}
+	for i, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+
+		logCyan("[%d/%d] %s", i+1, len(entries), entry.url)
+		opts, err := entry.flags.entryOptions(base)
+		if err == nil {
+			err = download(ctx, session, entry.url, opts)
+		}
+
+		if err != nil {
+			logError(err)
+			codes[exitCode(err)] = true
+			failed++
+		}
+	}
+
+	if ctx.Err() != nil {
+		return withCode(exitInterrupted, fmt.Errorf("interrupted, partial downloads were kept in %q", tempDir))
+	}
+
+	if failed == 0 {
+		return nil
+	}
+
+	// When every url failed for the same reason, the batch fails with it too
+	code := exitPartial
+	if failed == len(entries) && codes[exitPartial] == false {
+		code = exitTotal
+		if len(codes) == 1 {
+			for only := range codes {
+				code = only
+			}
+		}
+	}
+	return withCode(code, fmt.Errorf("%d of %d url(s) failed to download", failed, len(entries)))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseEpisodeRanges(t *testing.T) {
+	tests := []struct {
+		value     string
+		want      episodeRanges
+		formatted string
+		err       bool
+	}{
+		{value: "", want: nil, formatted: ""},
+		{value: "5", want: episodeRanges{{5, 5}}, formatted: "5"},
+		{value: "1-3", want: episodeRanges{{1, 3}}, formatted: "1-3"},
+		{value: "10-", want: episodeRanges{{10, 0}}, formatted: "10-"},
+		{value: "1-3,5,10-", want: episodeRanges{{1, 3}, {5, 5}, {10, 0}}, formatted: "1-3,5,10-"},
+		{value: "1-3, 5", want: episodeRanges{{1, 3}, {5, 5}}, formatted: "1-3,5"},
+		{value: "2-2", want: episodeRanges{{2, 2}}, formatted: "2"},
+		{value: "0", err: true},
+		{value: "-3", err: true},
+		{value: "3-1", err: true},
+		{value: "a", err: true},
+		{value: "1-b", err: true},
+		{value: "1,,2", err: true},
+	}
+
+	for _, test := range tests {
+		got, err := parseEpisodeRanges(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("parseEpisodeRanges(%q) = %v, want an error", test.value, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseEpisodeRanges(%q) returned error: %v", test.value, err)
+			continue
+		}
+
+		if reflect.DeepEqual(got, test.want) == false {
+			t.Errorf("parseEpisodeRanges(%q) = %v, want %v", test.value, got, test.want)
+		}
+
+		if got.String() != test.formatted {
+			t.Errorf("parseEpisodeRanges(%q).String() = %q, want %q", test.value, got.String(), test.formatted)
+		}
+	}
+}
+
+func TestEntryFlags(t *testing.T) {
+	base := downloadFlags{quality: "720", subs: "en-US", output: "{series}", parallel: 2}
+
+	tests := []struct {
+		args []string
+		want downloadFlags
+		err  bool
+	}{
+		{args: nil, want: base},
+		{args: []string{"quality=1080"}, want: downloadFlags{quality: "1080", subs: "en-US", output: "{series}", parallel: 2}},
+		{args: []string{"q=480", "s=es-MX"}, want: downloadFlags{quality: "480", subs: "es-MX", output: "{series}", parallel: 2}},
+		{args: []string{"dub=true", "episodes=1-3"}, want: downloadFlags{quality: "720", subs: "en-US", output: "{series}", parallel: 2, dub: true, episodes: "1-3"}},
+		{args: []string{"-subs=ja-JP", "output={title}"}, want: downloadFlags{quality: "720", subs: "ja-JP", output: "{title}", parallel: 2}},
+		{args: []string{"parallel-episodes=3"}, err: true},
+		{args: []string{"episodes=3-1"}, err: true},
+		{args: []string{"dub=maybe"}, err: true},
+		{args: []string{"quality=1080", "--", "extra"}, err: true},
+	}
+
+	for _, test := range tests {
+		got, err := entryFlags(base, append([]string(nil), test.args...))
+		if test.err {
+			if err == nil {
+				t.Errorf("entryFlags(%q) = %+v, want an error", test.args, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("entryFlags(%q) returned error: %v", test.args, err)
+		} else if reflect.DeepEqual(got, test.want) == false {
+			t.Errorf("entryFlags(%q) = %+v, want %+v", test.args, got, test.want)
+		}
+	}
+}
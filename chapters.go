@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/turtletowerz/crunchyrip/crunchyroll"
+	"github.com/turtletowerz/crunchyrip/hls"
+)
+
+// parseTimestamp parses a time such as "1:23:45", "21:30" or "90.5".
+func parseTimestamp(value string) (time.Duration, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+
+	var seconds float64
+	for _, part := range parts {
+		amount, err := strconv.ParseFloat(part, 64)
+		if err != nil || amount < 0 {
+			return 0, fmt.Errorf("invalid time %q", value)
+		}
+		seconds = seconds*60 + amount
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// section is the part of each episode given by -section. end is zero for the
+// rest of the episode.
+type section struct {
+	start time.Duration
+	end   time.Duration
+}
+
+// parseSection parses a section such as "21:30-23:00", or "21:30-" for
+// everything after 21:30.
+func parseSection(value string) (*section, error) {
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid section %q, expected start-end", value)
+	}
+
+	start, err := parseTimestamp(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid section start: %w", err)
+	}
+
+	s := &section{start: start}
+	if end := strings.TrimSpace(parts[1]); end != "" {
+		if s.end, err = parseTimestamp(end); err != nil {
+			return nil, fmt.Errorf("invalid section end: %w", err)
+		}
+
+		if s.end <= s.start {
+			return nil, fmt.Errorf("section %q ends before it starts", value)
+		}
+	}
+	return s, nil
+}
+
+// String returns the section as it is added to file names, ex. "21m30s-23m0s".
+func (s *section) String() string {
+	if s.end == 0 {
+		return s.start.String() + "-end"
+	}
+	return s.start.String() + "-" + s.end.String()
+}
+
+// readChapterFile reads the chapters given by -chapters, one per line as the
+// start time followed by the title, ex. "00:01:30 Part A". Blank lines and
+// lines starting with # are skipped. Each chapter ends where the next one
+// starts, and the last one is left open until the length of the episode is
+// known.
+func readChapterFile(filename string) ([]hls.Chapter, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening chapters file: %w", err)
+	}
+	defer file.Close()
+
+	var chapters []hls.Chapter
+	scanner := bufio.NewScanner(file)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(text, " ", 2)
+		start, err := parseTimestamp(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("chapters file line %d: %w", line, err)
+		}
+
+		if len(chapters) > 0 && start <= chapters[len(chapters)-1].Start {
+			return nil, fmt.Errorf("chapters file line %d: chapters must be in order", line)
+		}
+
+		title := fmt.Sprintf("Chapter %d", len(chapters)+1)
+		if len(fields) == 2 && strings.TrimSpace(fields[1]) != "" {
+			title = strings.TrimSpace(fields[1])
+		}
+
+		if len(chapters) > 0 {
+			chapters[len(chapters)-1].End = start
+		}
+		chapters = append(chapters, hls.Chapter{Title: title, Start: start})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading chapters file: %w", err)
+	}
+
+	if len(chapters) == 0 {
+		return nil, fmt.Errorf("chapters file %q has no chapters", filename)
+	}
+	return chapters, nil
+}
+
+// episodeChapters returns the chapters of an episode that is duration long.
+// The chapters of -chapters are used if it was given, otherwise they are split
+// at the playlist's discontinuities and at the clips the episode's page marks,
+// taking the names of the clips. There are no chapters if nothing splits the
+// episode.
+func episodeChapters(episode *crunchyroll.Episode, discontinuities []time.Duration, duration time.Duration, file []hls.Chapter) []hls.Chapter {
+	if len(file) > 0 {
+		chapters := []hls.Chapter{}
+		for _, chapter := range file {
+			if chapter.Start < duration {
+				chapters = append(chapters, chapter)
+			}
+		}
+
+		if last := len(chapters) - 1; last >= 0 && (chapters[last].End == 0 || chapters[last].End > duration) {
+			chapters[last].End = duration
+		}
+		return chapters
+	}
+
+	names := map[time.Duration]string{}
+	starts := []time.Duration{0}
+	add := func(start time.Duration) {
+		if start >= 0 && start < duration {
+			starts = append(starts, start)
+		}
+	}
+
+	for _, start := range discontinuities {
+		add(start)
+	}
+
+	for _, clip := range episode.Clips {
+		add(clip.Start)
+		if clip.End > clip.Start {
+			add(clip.End)
+		}
+
+		if clip.Name != "" {
+			names[clip.Start] = clip.Name
+		}
+	}
+
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	// Boundaries less than a second apart are treated as one
+	var chapters []hls.Chapter
+	for i, start := range starts {
+		if i > 0 && start-chapters[len(chapters)-1].Start < time.Second {
+			if name, ok := names[start]; ok {
+				chapters[len(chapters)-1].Title = name
+			}
+			continue
+		}
+
+		if len(chapters) > 0 {
+			chapters[len(chapters)-1].End = start
+		}
+
+		title, ok := names[start]
+		if ok == false {
+			title = fmt.Sprintf("Chapter %d", len(chapters)+1)
+		}
+		chapters = append(chapters, hls.Chapter{Title: title, Start: start, End: duration})
+	}
+
+	if len(chapters) < 2 {
+		return nil
+	}
+	return chapters
+}
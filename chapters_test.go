@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/turtletowerz/crunchyrip/crunchyroll"
+	"github.com/turtletowerz/crunchyrip/hls"
+)
+
+func TestParseSection(t *testing.T) {
+	tests := []struct {
+		value      string
+		start, end time.Duration
+		formatted  string
+		err        bool
+	}{
+		{value: "21:30-23:00", start: 21*time.Minute + 30*time.Second, end: 23 * time.Minute, formatted: "21m30s-23m0s"},
+		{value: "21:30-", start: 21*time.Minute + 30*time.Second, formatted: "21m30s-end"},
+		{value: "0-90.5", start: 0, end: 90*time.Second + 500*time.Millisecond, formatted: "0s-1m30.5s"},
+		{value: "1:00:00-1:02:03", start: time.Hour, end: time.Hour + 2*time.Minute + 3*time.Second, formatted: "1h0m0s-1h2m3s"},
+		{value: " 10 - 20 ", start: 10 * time.Second, end: 20 * time.Second, formatted: "10s-20s"},
+		{value: "21:30", err: true},
+		{value: "23:00-21:30", err: true},
+		{value: "10-10", err: true},
+		{value: "a-10", err: true},
+		{value: "10-b", err: true},
+		{value: "1:2:3:4-", err: true},
+		{value: "-10", err: true},
+	}
+
+	for _, test := range tests {
+		got, err := parseSection(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("parseSection(%q) = %v, want an error", test.value, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseSection(%q) returned error: %v", test.value, err)
+			continue
+		}
+
+		if got.start != test.start || got.end != test.end {
+			t.Errorf("parseSection(%q) = %s to %s, want %s to %s", test.value, got.start, got.end, test.start, test.end)
+		}
+
+		if got.String() != test.formatted {
+			t.Errorf("parseSection(%q).String() = %q, want %q", test.value, got.String(), test.formatted)
+		}
+	}
+}
+
+func TestEpisodeChapters(t *testing.T) {
+	const duration = 24 * time.Minute
+	s := time.Second
+
+	tests := []struct {
+		name            string
+		clips           []crunchyroll.Clip
+		discontinuities []time.Duration
+		file            []hls.Chapter
+		want            []hls.Chapter
+	}{
+		{
+			name: "nothing splits the episode",
+			want: nil,
+		},
+		{
+			name:            "discontinuities",
+			discontinuities: []time.Duration{300 * s, 1200 * s},
+			want: []hls.Chapter{
+				{Title: "Chapter 1", Start: 0, End: 300 * s},
+				{Title: "Chapter 2", Start: 300 * s, End: 1200 * s},
+				{Title: "Chapter 3", Start: 1200 * s, End: duration},
+			},
+		},
+		{
+			name:  "named clips",
+			clips: []crunchyroll.Clip{{Name: "Opening", Start: 0, End: 90 * s}, {Name: "Ending", Start: 1350 * s, End: duration}},
+			want: []hls.Chapter{
+				{Title: "Opening", Start: 0, End: 90 * s},
+				{Title: "Chapter 2", Start: 90 * s, End: 1350 * s},
+				{Title: "Ending", Start: 1350 * s, End: duration},
+			},
+		},
+		{
+			name:            "boundaries under a second apart",
+			clips:           []crunchyroll.Clip{{Name: "Part B", Start: 720 * s}},
+			discontinuities: []time.Duration{720*s - 400*time.Millisecond},
+			want: []hls.Chapter{
+				{Title: "Chapter 1", Start: 0, End: 720*s - 400*time.Millisecond},
+				{Title: "Part B", Start: 720*s - 400*time.Millisecond, End: duration},
+			},
+		},
+		{
+			name:            "boundaries outside the episode",
+			discontinuities: []time.Duration{-s, duration, duration + 10*s},
+			want:            nil,
+		},
+		{
+			name:            "chapters file",
+			discontinuities: []time.Duration{300 * s},
+			file:            []hls.Chapter{{Title: "A", Start: 0, End: 60 * s}, {Title: "B", Start: 60 * s, End: 2000 * s}, {Title: "C", Start: 2000 * s}},
+			want:            []hls.Chapter{{Title: "A", Start: 0, End: 60 * s}, {Title: "B", Start: 60 * s, End: duration}},
+		},
+		{
+			name: "chapters file left open",
+			file: []hls.Chapter{{Title: "A", Start: 0, End: 60 * s}, {Title: "B", Start: 60 * s}},
+			want: []hls.Chapter{{Title: "A", Start: 0, End: 60 * s}, {Title: "B", Start: 60 * s, End: duration}},
+		},
+	}
+
+	for _, test := range tests {
+		episode := &crunchyroll.Episode{Clips: test.clips}
+		file := append([]hls.Chapter(nil), test.file...)
+
+		got := episodeChapters(episode, test.discontinuities, duration, file)
+		if reflect.DeepEqual(got, test.want) == false {
+			t.Errorf("%s: episodeChapters = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,604 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/turtletowerz/crunchyrip/crunchyroll"
+	"github.com/turtletowerz/crunchyrip/hls"
+)
+
+// command is a subcommand of crunchyrip. run receives the arguments that come
+// after the name of the command and returns the exit code.
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(cmd *command, args []string) int
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"login", "username password", "Log in and save the session for the other commands", runLogin},
+		{"logout", "", "Remove the saved session", runLogout},
+		{"list", "[username password] series-url", "Print the seasons and episodes of a series", runList},
+		{"info", "[username password] url", "Print the streams, qualities and subtitles of an episode or season", runInfo},
+		{"download", "[username password] url...", "Download series or episodes (default)", runDownload},
+		{"watch", "[username password] series-url", "Keep downloading the new episodes of a series", runWatch},
+		{"serve", "[username password]", "Run an HTTP API that queues downloads", runServe},
+	}
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func usage() {
+	logInfo("Usage: crunchyrip <command> [flags] [arguments]")
+	for _, cmd := range commands {
+		writeOutput("  %-9s %s", cmd.name, cmd.summary)
+	}
+	writeOutput("\nRun `crunchyrip <command> -h` to see the flags of a command. Without a command,\ncrunchyrip downloads: crunchyrip [flags] username password url")
+}
+
+// globalFlags are the flags that every command accepts.
+type globalFlags struct {
+	timeout      time.Duration
+	stallTimeout time.Duration
+	json         bool
+	profile      string
+	proxy        string
+}
+
+func newFlagSet(cmd *command) (*flag.FlagSet, *globalFlags) {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		logInfo("Usage: crunchyrip %s [flags] %s", cmd.name, cmd.args)
+		fs.PrintDefaults()
+	}
+
+	g := &globalFlags{}
+	fs.DurationVar(&g.timeout, "timeout", 30*time.Second, "Time to wait for a server to respond to a request (default 30s)")
+	fs.DurationVar(&g.stallTimeout, "stall-timeout", 20*time.Second, "Abort a transfer that receives no data for this long, 0 disables it (default 20s)")
+	fs.BoolVar(&g.json, "json", false, "If true, will print JSON records to stdout instead of coloured text (default false)")
+	fs.StringVar(&g.profile, "profile", "", "Profile of the config file to take the default flags from")
+	fs.StringVar(&g.proxy, "proxy", "", "Proxy url for every request, ex. http://127.0.0.1:3128 or socks5://127.0.0.1:1080 (default from the environment)")
+	return fs, g
+}
+
+// parseFlags parses the flags of a command, then fills in the ones that weren't
+// given from the config file. If the command shouldn't go on, it returns false
+// along with the exit code.
+func parseFlags(fs *flag.FlagSet, g *globalFlags, args []string) (int, bool) {
+	// -json is looked for first so that errors in the other flags are
+	// reported as JSON too, with the usage going to stderr
+	jsonOutput = jsonRequested(args)
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK, false
+		}
+
+		if jsonOutput {
+			emitError(err)
+		}
+		return exitUsage, false
+	}
+
+	jsonOutput = g.json
+	if err := applyConfig(fs, g.profile); err != nil {
+		logError(fmt.Errorf("reading config: %w", err))
+		return exitUsage, false
+	}
+	jsonOutput = g.json
+	return 0, true
+}
+
+// jsonRequested reports whether args turn on -json, before they are parsed.
+func jsonRequested(args []string) bool {
+	requested := false
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+
+		if strings.HasPrefix(arg, "-") == false {
+			continue
+		}
+
+		name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if name == "json" {
+			requested = true
+		} else if strings.HasPrefix(name, "json=") {
+			requested, _ = strconv.ParseBool(strings.TrimPrefix(name, "json="))
+		}
+	}
+	return requested
+}
+
+// downloadFlags are the flags of the commands that download episodes.
+type downloadFlags struct {
+	quality     string
+	subs        string
+	dub         bool
+	options     bool
+	parallel    int
+	connections int
+	limitRate   string
+	events      string
+	output      string
+	tempDir     string
+	episodes    string
+	failedList  string
+	dryRun      bool
+	nfo         bool
+	artwork     bool
+	infoJSON    bool
+	chapters    string
+	stripAds    bool
+	section     string
+	audioOnly   bool
+	audioFormat string
+	transcode   string
+	execAfter   string
+}
+
+func addDownloadFlags(fs *flag.FlagSet) *downloadFlags {
+	f := &downloadFlags{}
+	fs.BoolVar(&f.options, "options", false, "If true, will print the streams, qualities and subtitles like info instead of downloading")
+	addStreamFlags(fs, f)
+	fs.IntVar(&f.parallel, "parallel-episodes", 1, "Number of episodes to download at the same time (default 1)")
+	fs.IntVar(&f.connections, "connections", 0, "Fixed number of segment connections, 0 tunes it automatically (default 0)")
+	fs.StringVar(&f.events, "events", "", "Also write download events as JSON lines to this file, or - for stdout")
+	fs.StringVar(&f.limitRate, "limit-rate", "0", "Maximum download speed shared by every connection, ex. 500K or 5M (default unlimited)")
+	fs.StringVar(&f.output, "output", "", "Path of each episode without the extension, using {series}, {season}, {season_name}, {season_title}, {episode}, {title}, {air_date}, {media_id} and {series_id} (default \"{series}/{season_name}/{series} - S{season}E{episode} - {title}\" for a series)")
+	fs.StringVar(&f.tempDir, "temp-dir", os.TempDir(), "Directory to keep the crunchyrip folder of unfinished downloads in")
+	fs.StringVar(&f.episodes, "episodes", "", "Episodes of a series to download by their position, ex. 1-3,5,10- (default all)")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "If true, will print the path, quality, languages and estimated size of each episode without downloading it")
+	fs.BoolVar(&f.nfo, "nfo", false, "If true, will write Kodi/Jellyfin .nfo files for each episode, its season and its series")
+	fs.BoolVar(&f.artwork, "artwork", false, "If true, will download the thumbnail of each episode and the poster and banner of its series")
+	fs.BoolVar(&f.infoJSON, "info-json", false, "If true, will save everything known about each episode and its stream to a .info.json file beside it")
+	fs.StringVar(&f.chapters, "chapters", "", "File of chapters to write to every episode, one \"00:01:30 Title\" per line, or none to leave them out (default from the stream and episode page)")
+	fs.BoolVar(&f.stripAds, "strip-ads", false, "If true, will leave the parts of the stream that look like ads out of each episode")
+	fs.StringVar(&f.section, "section", "", "Only download this part of each episode, ex. 21:30-23:00 or 21:30- for the rest of it (default the whole episode)")
+	fs.BoolVar(&f.audioOnly, "audio-only", false, "If true, will only download the audio of each episode")
+	fs.StringVar(&f.audioFormat, "audio-format", "m4a", "Format of -audio-only: m4a copies the AAC audio, opus encodes it (default m4a)")
+	fs.StringVar(&f.transcode, "transcode", "", "Re-encode each episode after it is converted with this profile: mobile, small, hevc or a [transcode name] section of the config file (default none)")
+	fs.StringVar(&f.execAfter, "exec-after", "", "Command to run after each episode is saved, with {path}, {series}, {season}, {episode}, {title} and the other -output fields, which are quoted already, also set as CRUNCHYRIP_PATH etc.")
+	fs.StringVar(&f.failedList, "failed-list", "crunchyrip-failed.txt", "File to write the urls that failed to, which can be passed back to -batch, or empty to skip it")
+	return f
+}
+
+// addStreamFlags adds the flags that choose the stream of an episode.
+func addStreamFlags(fs *flag.FlagSet, f *downloadFlags) {
+	fs.BoolVar(&f.dub, "dub", false, "If true, will attempt to download English version")
+	fs.StringVar(&f.subs, "subs", "en-US", "Subtitle language: en-US, ja-JP (default en-US)")
+	fs.StringVar(&f.subs, "s", f.subs, "Subtitle language: en-US, ja-JP (default en-US) (shorthand)")
+	fs.StringVar(&f.quality, "quality", "720", "Stream quality (default 720)")
+	fs.StringVar(&f.quality, "q", f.quality, "Stream quality (shorthand)")
+}
+
+// downloadOptions builds the options for download from the flags. The returned
+// function closes the events file, if one was opened.
+func (f *downloadFlags) downloadOptions() (downloadOptions, func(), error) {
+	opts, err := f.entryOptions(downloadOptions{
+		parallel:    f.parallel,
+		connections: f.connections,
+		dryRun:      f.dryRun,
+		nfo:         f.nfo,
+		artwork:     f.artwork,
+		infoJSON:    f.infoJSON,
+		noChapters:  f.chapters == "none",
+		stripAds:    f.stripAds,
+		execAfter:   f.execAfter,
+	})
+	closer := func() {}
+	if err != nil {
+		return opts, closer, withCode(exitUsage, err)
+	}
+	tempDir = filepath.Join(f.tempDir, "crunchyrip")
+
+	if f.audioOnly {
+		if f.audioFormat != "m4a" && f.audioFormat != "opus" {
+			return opts, closer, withCode(exitUsage, fmt.Errorf("invalid audio format %q, expected m4a or opus", f.audioFormat))
+		}
+		opts.audioFormat = f.audioFormat
+	}
+
+	if f.transcode != "" {
+		if f.audioOnly {
+			return opts, closer, withCode(exitUsage, fmt.Errorf("-transcode can't be used with -audio-only"))
+		}
+
+		profile, err := transcodeProfile(f.transcode)
+		if err != nil {
+			return opts, closer, withCode(exitUsage, err)
+		}
+		opts.transcode = &profile
+	}
+
+	if f.section != "" {
+		if opts.section, err = parseSection(f.section); err != nil {
+			return opts, closer, withCode(exitUsage, err)
+		}
+	}
+
+	if f.chapters != "" && f.chapters != "none" {
+		if opts.chapters, err = readChapterFile(f.chapters); err != nil {
+			return opts, closer, withCode(exitUsage, err)
+		}
+	}
+
+	rate, err := hls.ParseRate(f.limitRate)
+	if err != nil {
+		return opts, closer, withCode(exitUsage, err)
+	}
+
+	opts.limiter = hls.NewRateLimiter(rate)
+	watchRateSignals(opts.limiter)
+	if rate > 0 {
+		logInfo("Rate limit: %s", hls.FormatRate(rate))
+	}
+
+	reporters := hls.Reporters{newTerminalReporter()}
+	if jsonOutput {
+		reporters = hls.Reporters{hls.NewJSONReporter(jsonWriter)}
+	}
+
+	if f.events != "" && (f.events != "-" || jsonOutput == false) {
+		var writer io.Writer = os.Stdout
+		if f.events != "-" {
+			file, err := os.Create(f.events)
+			if err != nil {
+				return opts, closer, withCode(exitUsage, fmt.Errorf("creating events file: %w", err))
+			}
+			closer = func() { file.Close() }
+			writer = file
+		}
+		reporters = append(reporters, hls.NewJSONReporter(writer))
+	}
+
+	opts.reporter = reporters
+	return opts, closer, nil
+}
+
+// entryOptions returns base with the settings that can differ between the urls
+// of a batch taken from f.
+func (f *downloadFlags) entryOptions(base downloadOptions) (downloadOptions, error) {
+	episodes, err := parseEpisodeRanges(f.episodes)
+	if err != nil {
+		return base, err
+	}
+
+	base.quality = f.quality
+	base.subLang = f.subs
+	base.dubbed = f.dub
+	base.output = f.output
+	base.episodes = episodes
+	return base, nil
+}
+
+// openSession logs in with the username and password at the start of args, or
+// loads the saved session if they were left out. want is the number of other
+// arguments the command needs, which are returned.
+func openSession(ctx context.Context, cmd *command, g *globalFlags, args []string, want int) (*crunchyroll.Session, []string, error) {
+	if len(args) != want && len(args) != want+2 {
+		return nil, nil, withCode(exitUsage, fmt.Errorf("usage: crunchyrip %s [flags] %s", cmd.name, cmd.args))
+	}
+
+	var proxy *url.URL
+	if g.proxy != "" {
+		var err error
+		if proxy, err = url.Parse(g.proxy); err != nil || proxy.Host == "" {
+			return nil, nil, withCode(exitUsage, fmt.Errorf("invalid proxy url %q", g.proxy))
+		}
+	}
+
+	logCyan("crunchyrip %s - by turtletowerz", version)
+	session := crunchyroll.NewSession(ctx, g.timeout, g.stallTimeout, proxy)
+
+	if len(args) == want {
+		if err := loadSession(session); err != nil {
+			return nil, nil, withCode(exitAuth, err)
+		}
+
+		if err := session.Verify(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+
+			if errors.Is(err, crunchyroll.ErrLoginFailed) {
+				return nil, nil, withCode(exitAuth, errors.New("the saved session has expired, run `crunchyrip login username password` again"))
+			}
+			return nil, nil, withCode(exitAuth, fmt.Errorf("checking the saved session: %w", err))
+		}
+		logInfo("Using the saved Crunchyroll session")
+		return session, args, nil
+	}
+
+	logCyan("Attempting to login to crunchyroll account")
+	logInfo("Logging into Crunchyroll...")
+	logInfo("User-Agent: " + session.UserAgent)
+
+	if err := session.Login(ctx, args[0], args[1]); err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, withCode(exitAuth, err)
+	}
+
+	logSuccess("Crunchyroll login successful!")
+	return session, args[2:], nil
+}
+
+func runLogin(cmd *command, args []string) int {
+	fs, g := newFlagSet(cmd)
+	if code, ok := parseFlags(fs, g, args); ok == false {
+		return code
+	}
+
+	if fs.NArg() != 2 {
+		return exitWith(withCode(exitUsage, fmt.Errorf("usage: crunchyrip %s [flags] %s", cmd.name, cmd.args)))
+	}
+
+	ctx := interruptContext()
+	session, _, err := openSession(ctx, cmd, g, fs.Args(), 0)
+	if err != nil {
+		return exitWith(err)
+	}
+
+	path, err := saveSession(session)
+	if err != nil {
+		return exitWith(err)
+	}
+
+	logSuccess("Saved session to %s", path)
+	emit(struct {
+		Type string `json:"type"`
+		Path string `json:"path"`
+	}{"login", path})
+	return exitOK
+}
+
+func runLogout(cmd *command, args []string) int {
+	fs, g := newFlagSet(cmd)
+	if code, ok := parseFlags(fs, g, args); ok == false {
+		return code
+	}
+
+	path, err := removeSession()
+	if err != nil {
+		return exitWith(err)
+	}
+
+	logSuccess("Removed session %s", path)
+	emit(struct {
+		Type string `json:"type"`
+		Path string `json:"path"`
+	}{"logout", path})
+	return exitOK
+}
+
+func runList(cmd *command, args []string) int {
+	fs, g := newFlagSet(cmd)
+	if code, ok := parseFlags(fs, g, args); ok == false {
+		return code
+	}
+
+	ctx := interruptContext()
+	session, rest, err := openSession(ctx, cmd, g, fs.Args(), 1)
+	if err != nil {
+		return exitWith(err)
+	}
+
+	if err := listSeries(ctx, session, rest[0]); err != nil {
+		return exitWith(err)
+	}
+	return exitOK
+}
+
+// seasonRecord is written by `list` for each season.
+type seasonRecord struct {
+	Type     string          `json:"type"`
+	Title    string          `json:"title"`
+	Episodes []episodeRecord `json:"episodes"`
+}
+
+func listSeries(ctx context.Context, session *crunchyroll.Session, seriesURL string) error {
+	series, err := crunchyroll.IsSeries(seriesURL)
+	if err != nil {
+		return withCode(exitNotFound, err)
+	}
+
+	if series == false {
+		return withCode(exitUsage, fmt.Errorf("%q is an episode, use `info` to see its details", seriesURL))
+	}
+
+	seasons, err := session.Seasons(ctx, seriesURL)
+	if err != nil {
+		return fmt.Errorf("getting seasons: %w", err)
+	}
+
+	for _, season := range seasons {
+		title := season.Title
+		if title == "" {
+			title = "Episodes"
+		}
+
+		record := seasonRecord{Type: "season", Title: season.Title, Episodes: []episodeRecord{}}
+		logCyan("%s (%d episodes)", title, len(season.Episodes))
+		for i, episode := range season.Episodes {
+			writeOutput("  %3d. %s - %s", i+1, episode.Title, episode.EpisodeURL)
+			record.Episodes = append(record.Episodes, episodeRecord{Type: "episode", URL: episode.EpisodeURL, Title: episode.Title})
+		}
+		emit(record)
+	}
+	return nil
+}
+
+func addInfoFlags(fs *flag.FlagSet) (*downloadFlags, *bool) {
+	f := &downloadFlags{}
+	addStreamFlags(fs, f)
+	season := fs.Bool("season", false, "If true, will summarize every episode of the season instead of only the first one")
+	return f, season
+}
+
+func runInfo(cmd *command, args []string) int {
+	fs, g := newFlagSet(cmd)
+	f, season := addInfoFlags(fs)
+	if code, ok := parseFlags(fs, g, args); ok == false {
+		return code
+	}
+
+	ctx := interruptContext()
+	session, rest, err := openSession(ctx, cmd, g, fs.Args(), 1)
+	if err != nil {
+		return exitWith(err)
+	}
+
+	if *season {
+		err = printSeasonInfo(ctx, session, rest[0], f)
+	} else {
+		err = printInfo(ctx, session, rest[0], f)
+	}
+
+	if err != nil {
+		return exitWith(err)
+	}
+	return exitOK
+}
+
+func addBatchFlags(fs *flag.FlagSet) (*downloadFlags, *string) {
+	f := addDownloadFlags(fs)
+	batch := fs.String("batch", "", "File with a url on each line, optionally followed by overrides ex. quality=1080 subs=es-MX dub=true episodes=1-3")
+	return f, batch
+}
+
+func runDownload(cmd *command, args []string) int {
+	fs, g := newFlagSet(cmd)
+	f, batch := addBatchFlags(fs)
+	if code, ok := parseFlags(fs, g, args); ok == false {
+		return code
+	}
+
+	var entries []batchEntry
+	if *batch != "" {
+		var err error
+		if entries, err = readBatch(*batch, *f); err != nil {
+			return exitWith(withCode(exitUsage, err))
+		}
+	}
+
+	// Every argument after the username and password is a url
+	args = fs.Args()
+	want := len(args)
+	if want >= 2 && isURL(args[0]) == false {
+		want -= 2
+	}
+
+	if want == 0 && *batch == "" {
+		return exitWith(withCode(exitUsage, fmt.Errorf("usage: crunchyrip %s [flags] %s", cmd.name, cmd.args)))
+	}
+
+	ctx := interruptContext()
+	session, urls, err := openSession(ctx, cmd, g, args, want)
+	if err != nil {
+		return exitWith(err)
+	}
+
+	for _, url := range urls {
+		entries = append(entries, batchEntry{url: url, flags: *f})
+	}
+
+	if f.options {
+		for _, entry := range entries {
+			if err := printInfo(ctx, session, entry.url, &entry.flags); err != nil {
+				return exitWith(err)
+			}
+		}
+		return exitOK
+	}
+
+	opts, closer, err := f.downloadOptions()
+	defer closer()
+	if err != nil {
+		return exitWith(err)
+	}
+
+	opts.summary = newSummary()
+	if len(entries) == 1 && *batch == "" {
+		err = download(ctx, session, entries[0].url, opts)
+	} else {
+		err = downloadBatch(ctx, session, entries, opts)
+	}
+
+	if listErr := opts.summary.print(f.failedList); listErr != nil {
+		logError(listErr)
+	}
+
+	if err != nil {
+		return exitWith(err)
+	}
+	return exitOK
+}
+
+func addWatchFlags(fs *flag.FlagSet) (*downloadFlags, *time.Duration) {
+	f := addDownloadFlags(fs)
+	interval := fs.Duration("interval", time.Hour, "Time to wait between checks for new episodes (default 1h)")
+	return f, interval
+}
+
+func runWatch(cmd *command, args []string) int {
+	fs, g := newFlagSet(cmd)
+	f, interval := addWatchFlags(fs)
+	if code, ok := parseFlags(fs, g, args); ok == false {
+		return code
+	}
+
+	ctx := interruptContext()
+	session, rest, err := openSession(ctx, cmd, g, fs.Args(), 1)
+	if err != nil {
+		return exitWith(err)
+	}
+
+	opts, closer, err := f.downloadOptions()
+	defer closer()
+	if err != nil {
+		return exitWith(err)
+	}
+
+	// Episodes that were already downloaded are skipped, so every check only
+	// downloads the ones that came out since the last one
+	for {
+		opts.summary = newSummary()
+		if err := download(ctx, session, rest[0], opts); err != nil {
+			logError(err)
+		}
+
+		if err := opts.summary.print(f.failedList); err != nil {
+			logError(err)
+		}
+
+		logInfo("Checking for new episodes again in %s", *interval)
+		select {
+		case <-time.After(*interval):
+		case <-ctx.Done():
+			return exitInterrupted
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/turtletowerz/crunchyrip/hls"
+)
+
+// configSetting is a "key = value" line of the config file. Keys are the names
+// of flags, without the dash.
+type configSetting struct {
+	key   string
+	value string
+	line  int
+}
+
+func configPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config"), nil
+}
+
+// configFile holds the settings of the config file by where they are.
+type configFile struct {
+	defaults   []configSetting            // At the top, for every run
+	profiles   map[string][]configSetting // In each [profile name] section
+	transcodes map[string][]configSetting // In each [transcode name] section
+}
+
+// readConfig reads the settings at the top of the config file and in each of
+// its sections.
+func readConfig(path string) (*configFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config := &configFile{
+		profiles:   map[string][]configSetting{},
+		transcodes: map[string][]configSetting{},
+	}
+	sections := config.profiles
+	section := ""
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			header := strings.TrimSpace(strings.Trim(text, "[]"))
+			sections, section = config.profiles, header
+			if kind := strings.Fields(header); len(kind) > 0 && (kind[0] == "profile" || kind[0] == "transcode") {
+				section = strings.TrimSpace(strings.TrimPrefix(header, kind[0]))
+				if kind[0] == "transcode" {
+					sections = config.transcodes
+				}
+			}
+
+			if section == "" {
+				return nil, fmt.Errorf("%s line %d: empty section name", path, line)
+			}
+			if _, exists := sections[section]; exists == false {
+				sections[section] = []configSetting{}
+			}
+			continue
+		}
+
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s line %d: expected key = value", path, line)
+		}
+
+		setting := configSetting{
+			key:   strings.TrimLeft(strings.TrimSpace(parts[0]), "-"),
+			value: strings.Trim(strings.TrimSpace(parts[1]), `"`),
+			line:  line,
+		}
+
+		if section == "" {
+			config.defaults = append(config.defaults, setting)
+		} else {
+			sections[section] = append(sections[section], setting)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// settingNames returns the names of the flags of every command, which are the
+// settings the config file can have. A command with flags of its own needs its
+// add function listed here.
+func settingNames() map[string]bool {
+	adds := []func(fs *flag.FlagSet){
+		func(fs *flag.FlagSet) { addInfoFlags(fs) },
+		func(fs *flag.FlagSet) { addBatchFlags(fs) },
+		func(fs *flag.FlagSet) { addWatchFlags(fs) },
+		func(fs *flag.FlagSet) { addServeFlags(fs) },
+	}
+
+	names := map[string]bool{}
+	for _, add := range adds {
+		fs, _ := newFlagSet(&command{})
+		add(fs)
+		fs.VisitAll(func(f *flag.Flag) {
+			names[f.Name] = true
+		})
+	}
+	return names
+}
+
+// applyConfig sets the flags of fs from the config file, first from the
+// settings at the top and then from profile, if one was picked. Flags that
+// were given on the command line keep their value, and settings for flags
+// that fs doesn't have are left for the commands that do. A setting that no
+// command has is an error.
+func applyConfig(fs *flag.FlagSet, profile string) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	config, err := readConfig(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			if profile != "" {
+				return fmt.Errorf("profile %q not found, there is no config file at %s", profile, path)
+			}
+			return nil
+		}
+		return err
+	}
+
+	settings := config.defaults
+	if profile != "" {
+		values, exists := config.profiles[profile]
+		if exists == false {
+			return fmt.Errorf("profile %q not found in %s", profile, path)
+		}
+		settings = append(settings, values...)
+	}
+
+	// The shorthand flags share their value with the long ones, so setting
+	// either counts for both
+	var given []flag.Value
+	fs.Visit(func(f *flag.Flag) {
+		given = append(given, f.Value)
+	})
+
+	// Every profile is checked, not only the one that was picked, so a typo
+	// is found straight away
+	known := settingNames()
+	all := append([]configSetting{}, config.defaults...)
+	for _, values := range config.profiles {
+		all = append(all, values...)
+	}
+
+	for _, setting := range all {
+		if known[setting.key] == false {
+			return fmt.Errorf("%s line %d: unknown setting %q", path, setting.line, setting.key)
+		}
+	}
+
+	for _, setting := range settings {
+		// Settings of the other commands are skipped
+		f := fs.Lookup(setting.key)
+		if f == nil || containsValue(given, f.Value) {
+			continue
+		}
+
+		if err := fs.Set(setting.key, setting.value); err != nil {
+			return fmt.Errorf("%s line %d: %s: %w", path, setting.line, setting.key, err)
+		}
+	}
+	return nil
+}
+
+func containsValue(values []flag.Value, value flag.Value) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// transcodeProfile returns the transcode profile called name. It is read from
+// the [transcode name] section of the config file, which starts from the built
+// in profile of the same name if there is one.
+func transcodeProfile(name string) (hls.Profile, error) {
+	profile, builtIn := hls.Profiles[name]
+
+	path, err := configPath()
+	if err != nil {
+		return profile, err
+	}
+
+	config, err := readConfig(path)
+	if err != nil && os.IsNotExist(err) == false {
+		return profile, err
+	}
+
+	var settings []configSetting
+	if config != nil {
+		settings = config.transcodes[name]
+	}
+
+	if settings == nil && builtIn == false {
+		return profile, fmt.Errorf("transcode profile %q not found, the built in ones are %s", name, strings.Join(builtInProfiles(), ", "))
+	}
+
+	for _, setting := range settings {
+		var err error
+		switch strings.ReplaceAll(setting.key, "-", "_") {
+		case "video_codec":
+			profile.VideoCodec = setting.value
+		case "crf":
+			profile.CRF, err = strconv.Atoi(setting.value)
+		case "video_bitrate":
+			profile.VideoBitrate = setting.value
+		case "preset":
+			profile.Preset = setting.value
+		case "max_height":
+			profile.MaxHeight, err = strconv.Atoi(setting.value)
+		case "audio_codec":
+			profile.AudioCodec = setting.value
+		case "audio_bitrate":
+			profile.AudioBitrate = setting.value
+		case "loudnorm":
+			profile.Loudnorm, err = strconv.ParseBool(setting.value)
+		default:
+			err = errors.New("unknown setting")
+		}
+
+		if err != nil {
+			return profile, fmt.Errorf("%s line %d: %s: %w", path, setting.line, setting.key, err)
+		}
+	}
+
+	if err := profile.Validate(); err != nil {
+		return profile, fmt.Errorf("transcode profile %q: %w", name, err)
+	}
+	return profile, nil
+}
+
+func builtInProfiles() []string {
+	names := make([]string, 0, len(hls.Profiles))
+	for name := range hls.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
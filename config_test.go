@@ -0,0 +1,258 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/turtletowerz/crunchyrip/hls"
+)
+
+// useConfig points the config directory at a temporary one holding a config
+// file with content, or none if content is empty, and returns a function that
+// undoes it.
+func useConfig(t *testing.T, content string) func() {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "crunchyrip-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variables := []string{"XDG_CONFIG_HOME", "HOME", "AppData"}
+	old := map[string]string{}
+	for _, name := range variables {
+		old[name] = os.Getenv(name)
+		os.Setenv(name, dir)
+	}
+
+	undo := func() {
+		for _, name := range variables {
+			os.Setenv(name, old[name])
+		}
+		os.RemoveAll(dir)
+	}
+
+	path, err := configPath()
+	if err == nil && content != "" {
+		if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err == nil {
+			err = ioutil.WriteFile(path, []byte(content), 0644)
+		}
+	}
+
+	if err != nil {
+		undo()
+		t.Fatal(err)
+	}
+	return undo
+}
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		defaults   []string // key=value
+		profiles   map[string][]string
+		transcodes map[string][]string
+		err        string
+	}{
+		{
+			name:     "defaults",
+			content:  "# comment\n; comment\nquality = 1080\n--subs = \"es-MX\"\n",
+			defaults: []string{"quality=1080", "subs=es-MX"},
+		},
+		{
+			name:       "sections",
+			content:    "dub = true\n[profile anime]\nquality = 480\n[ transcode tiny ]\ncrf = 30\n[movies]\nquality = max\n[profile empty]\n",
+			defaults:   []string{"dub=true"},
+			profiles:   map[string][]string{"anime": {"quality=480"}, "movies": {"quality=max"}, "empty": nil},
+			transcodes: map[string][]string{"tiny": {"crf=30"}},
+		},
+		{
+			name:    "repeated section",
+			content: "[profile a]\nquality = 480\n[profile b]\ndub = true\n[profile a]\nsubs = ja-JP\n",
+			profiles: map[string][]string{
+				"a": {"quality=480", "subs=ja-JP"},
+				"b": {"dub=true"},
+			},
+		},
+		{
+			name:    "missing value",
+			content: "quality = 1080\nquality\n",
+			err:     "line 2: expected key = value",
+		},
+		{
+			name:    "empty section name",
+			content: "\n\n[profile ]\n",
+			err:     "line 3: empty section name",
+		},
+		{
+			name:    "empty transcode name",
+			content: "[transcode]\n",
+			err:     "line 1: empty section name",
+		},
+	}
+
+	for _, test := range tests {
+		undo := useConfig(t, test.content)
+		path, _ := configPath()
+		config, err := readConfig(path)
+		undo()
+
+		if test.err != "" {
+			if err == nil || strings.Contains(err.Error(), test.err) == false {
+				t.Errorf("%s: error = %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: returned error: %v", test.name, err)
+			continue
+		}
+
+		settings := func(values []configSetting) []string {
+			var pairs []string
+			for _, setting := range values {
+				pairs = append(pairs, setting.key+"="+setting.value)
+			}
+			return pairs
+		}
+
+		if got := settings(config.defaults); reflect.DeepEqual(got, test.defaults) == false {
+			t.Errorf("%s: defaults = %q, want %q", test.name, got, test.defaults)
+		}
+
+		sections := func(values map[string][]configSetting) map[string][]string {
+			pairs := map[string][]string{}
+			for name, section := range values {
+				pairs[name] = settings(section)
+			}
+			return pairs
+		}
+
+		for _, want := range []struct {
+			kind string
+			got  map[string][]string
+			want map[string][]string
+		}{
+			{"profiles", sections(config.profiles), test.profiles},
+			{"transcodes", sections(config.transcodes), test.transcodes},
+		} {
+			if want.want == nil {
+				want.want = map[string][]string{}
+			}
+
+			if reflect.DeepEqual(want.got, want.want) == false {
+				t.Errorf("%s: %s = %q, want %q", test.name, want.kind, want.got, want.want)
+			}
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		profile string
+		args    []string
+		quality string
+		subs    string
+		err     string
+	}{
+		{name: "no config file", content: "", quality: "720", subs: "en-US"},
+		{name: "defaults", content: "quality = 1080\ns = es-MX\n", quality: "1080", subs: "es-MX"},
+		{name: "profile over defaults", content: "quality = 1080\n[profile anime]\nquality = 480\n", profile: "anime", quality: "480", subs: "en-US"},
+		{name: "profile not picked", content: "quality = 1080\n[profile anime]\nquality = 480\n", quality: "1080", subs: "en-US"},
+		{name: "flag beats config", content: "quality = 1080\n", args: []string{"-quality", "360"}, quality: "360", subs: "en-US"},
+		{name: "shorthand flag beats config", content: "quality = 1080\n", args: []string{"-q", "360"}, quality: "360", subs: "en-US"},
+		{name: "flag beats shorthand setting", content: "s = es-MX\n", args: []string{"-subs", "ja-JP"}, quality: "720", subs: "ja-JP"},
+		{name: "flag beats profile", content: "[profile anime]\nquality = 480\n", profile: "anime", args: []string{"-q", "max"}, quality: "max", subs: "en-US"},
+		{name: "setting of another command", content: "interval = 2h\naddr = :8081\nseason = true\n", quality: "720", subs: "en-US"},
+		{name: "unknown setting", content: "quality = 1080\n\nqualty = 480\n", err: `line 3: unknown setting "qualty"`},
+		{name: "unknown setting in another profile", content: "[profile a]\nquality = 480\n[profile b]\nbogus = 1\n", profile: "a", err: `line 4: unknown setting "bogus"`},
+		{name: "missing profile", content: "quality = 1080\n", profile: "anime", err: `profile "anime" not found`},
+		{name: "missing profile without a config file", content: "", profile: "anime", err: `profile "anime" not found`},
+		{name: "invalid value", content: "dub = maybe\n", err: "line 1: dub"},
+	}
+
+	for _, test := range tests {
+		undo := useConfig(t, test.content)
+
+		fs, _ := newFlagSet(&command{name: "download"})
+		f, _ := addBatchFlags(fs)
+		if err := fs.Parse(test.args); err != nil {
+			undo()
+			t.Fatalf("%s: parsing %q: %v", test.name, test.args, err)
+		}
+
+		err := applyConfig(fs, test.profile)
+		undo()
+
+		if test.err != "" {
+			if err == nil || strings.Contains(err.Error(), test.err) == false {
+				t.Errorf("%s: error = %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: returned error: %v", test.name, err)
+		} else if f.quality != test.quality || f.subs != test.subs {
+			t.Errorf("%s: quality = %q and subs = %q, want %q and %q", test.name, f.quality, f.subs, test.quality, test.subs)
+		}
+	}
+}
+
+func TestTranscodeProfile(t *testing.T) {
+	mobile := hls.Profiles["mobile"]
+	mobile.CRF = 20
+	mobile.MaxHeight = 1080
+
+	tests := []struct {
+		name    string
+		content string
+		profile string
+		want    hls.Profile
+		err     string
+	}{
+		{name: "built in", content: "", profile: "mobile", want: hls.Profiles["mobile"]},
+		{name: "built in with other sections", content: "[profile mobile]\nquality = 480\n", profile: "hevc", want: hls.Profiles["hevc"]},
+		{name: "overridden built in", content: "[transcode mobile]\ncrf = 20\nmax-height = 1080\n", profile: "mobile", want: mobile},
+		{
+			name:    "custom",
+			content: "[transcode tiny]\nvideo-codec = libx264\nvideo_bitrate = 500k\npreset = fast\naudio_codec = libopus\naudio-bitrate = 48k\nloudnorm = true\n",
+			profile: "tiny",
+			want:    hls.Profile{VideoCodec: "libx264", VideoBitrate: "500k", Preset: "fast", AudioCodec: "libopus", AudioBitrate: "48k", Loudnorm: true},
+		},
+		{name: "missing", content: "[profile tiny]\nquality = 480\n", profile: "tiny", err: `transcode profile "tiny" not found`},
+		{name: "unknown setting", content: "[transcode tiny]\nvideo_codec = libx264\nspeed = 2\n", profile: "tiny", err: "line 3: speed: unknown setting"},
+		{name: "invalid number", content: "[transcode mobile]\ncrf = high\n", profile: "mobile", err: "line 2: crf"},
+		{name: "invalid profile", content: "[transcode tiny]\nvideo_codec = copy\ncrf = 20\naudio_codec = copy\n", profile: "tiny", err: `transcode profile "tiny": a copied video`},
+	}
+
+	for _, test := range tests {
+		undo := useConfig(t, test.content)
+		got, err := transcodeProfile(test.profile)
+		undo()
+
+		if test.err != "" {
+			if err == nil || strings.Contains(err.Error(), test.err) == false {
+				t.Errorf("%s: error = %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: returned error: %v", test.name, err)
+		} else if got != test.want {
+			t.Errorf("%s: profile = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+
+	if hls.Profiles["mobile"].CRF == 20 {
+		t.Errorf("overriding a built in profile changed hls.Profiles")
+	}
+}
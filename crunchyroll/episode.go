@@ -0,0 +1,355 @@
+package crunchyroll
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoStream is returned by FetchInfo when the episode has no stream with the
+// requested hardsub language. The rest of the episode's details are still set.
+var ErrNoStream error = errors.New("could not find stream")
+
+// Episode holds the details of a single episode, filled in by FetchInfo.
+type Episode struct {
+	Title        string
+	Number       string
+	SeriesTitle  string
+	SeasonNumber string
+	EpisodeURL   string
+	StreamURL    string
+	Streams      []Stream
+	Subtitles    []Subtitle
+	//SubtitleURL  string
+
+	MediaID      string
+	Description  string
+	AirDate      time.Time // Zero if the page doesn't list it
+	AudioLang    string    // The audio language of StreamURL
+	ThumbnailURL string
+
+	SeriesID      string
+	SeasonTitle   string
+	Duration      time.Duration // Zero if the page doesn't list it
+	ContentRating string        // Such as "TV-14", empty if the page doesn't list it
+	Clips         []Clip        // Marked parts such as the intro and credits, if the page lists them
+}
+
+// Clip is a named part of an episode, such as its intro or credits.
+type Clip struct {
+	Name  string
+	Start time.Duration
+	End   time.Duration
+}
+
+// Stream is one of the formats an episode can be played in. HardsubLang is
+// empty for the stream without burned in subtitles.
+type Stream struct {
+	Format      string `json:"format"`
+	AudioLang   string `json:"audio_lang"`
+	HardsubLang string `json:"hardsub_lang"`
+	URL         string `json:"url"`
+}
+
+// Subtitle is a soft subtitle track of an episode.
+type Subtitle struct {
+	Language string `json:"language"`
+	URL      string `json:"url"`
+	Format   string `json:"format"`
+}
+
+// looseString is a JSON string or number, for IDs that aren't always quoted.
+type looseString string
+
+func (l *looseString) UnmarshalJSON(data []byte) error {
+	if string(data) != "null" {
+		*l = looseString(strings.Trim(string(data), `"`))
+	}
+	return nil
+}
+
+type configStruct struct {
+	Streams   []Stream   `json:"streams"`
+	Subtitles []Subtitle `json:"subtitles"`
+
+	Thumbnail struct {
+		URL string `json:"url"`
+	} `json:"thumbnail"`
+
+	Metadata struct {
+		ID          looseString `json:"id"`
+		SeriesID    looseString `json:"series_id"`
+		Title       string      `json:"title"`
+		Description string      `json:"description"`
+		Duration    looseString `json:"duration"` // In milliseconds
+		//Number string `json:"episode_number"`
+		Number string `json:"display_episode_number"`
+	} `json:"metadata"`
+}
+
+type contextStruct struct {
+	Season struct {
+		Number string `json:"seasonNumber"`
+		Title  string `json:"name"`
+	} `json:"partOfSeason"`
+
+	Series struct {
+		Title string `json:"name"`
+	} `json:"partOfSeries"`
+
+	Description   string          `json:"description"`
+	Image         json.RawMessage `json:"image"`
+	DatePublished string          `json:"datePublished"`
+	UploadDate    string          `json:"uploadDate"`
+	Released      json.RawMessage `json:"releasedEvent"`
+	Duration      string          `json:"duration"`
+	TimeRequired  string          `json:"timeRequired"`
+	ContentRating json.RawMessage `json:"contentRating"`
+	HasPart       json.RawMessage `json:"hasPart"`
+}
+
+type clipStruct struct {
+	Type  string   `json:"@type"`
+	Name  string   `json:"name"`
+	Start *float64 `json:"startOffset"`
+	End   *float64 `json:"endOffset"`
+}
+
+type releasedEvent struct {
+	StartDate string `json:"startDate"`
+}
+
+// imageURL returns the url of a schema.org image, which is either a url, an
+// ImageObject or a list of them.
+func imageURL(raw json.RawMessage) string {
+	var url string
+	if json.Unmarshal(raw, &url) == nil {
+		return url
+	}
+
+	var object struct {
+		URL string `json:"url"`
+	}
+	if json.Unmarshal(raw, &object) == nil {
+		return object.URL
+	}
+
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil && len(list) > 0 {
+		return imageURL(list[0])
+	}
+	return ""
+}
+
+// ratingName returns the name of a schema.org contentRating, which is either
+// text, a Rating or a list of them.
+func ratingName(raw json.RawMessage) string {
+	var name string
+	if json.Unmarshal(raw, &name) == nil {
+		return name
+	}
+
+	var rating struct {
+		Name  string      `json:"name"`
+		Value looseString `json:"ratingValue"`
+	}
+	if json.Unmarshal(raw, &rating) == nil {
+		if rating.Name != "" {
+			return rating.Name
+		}
+		return string(rating.Value)
+	}
+
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil && len(list) > 0 {
+		return ratingName(list[0])
+	}
+	return ""
+}
+
+var isoDurationRegex = regexp.MustCompile(`^P(?:(\d+)D)?T?(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?$`)
+
+// parseISODuration parses an ISO 8601 duration such as "PT23M40S", returning
+// zero if it isn't one.
+func parseISODuration(value string) time.Duration {
+	match := isoDurationRegex.FindStringSubmatch(value)
+	if match == nil {
+		return 0
+	}
+
+	var duration time.Duration
+	for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if amount, err := strconv.ParseFloat(match[i+1], 64); err == nil {
+			duration += time.Duration(amount * float64(unit))
+		}
+	}
+	return duration
+}
+
+// clips returns the schema.org Clips in the page's context, which is either a
+// single part or a list of them. Their offsets are in seconds.
+func (c *contextStruct) clips() []Clip {
+	var parts []clipStruct
+	if err := json.Unmarshal(c.HasPart, &parts); err != nil {
+		var part clipStruct
+		if json.Unmarshal(c.HasPart, &part) == nil {
+			parts = append(parts, part)
+		}
+	}
+
+	var clips []Clip
+	for _, part := range parts {
+		if part.Type != "Clip" || part.Start == nil {
+			continue
+		}
+
+		clip := Clip{Name: part.Name, Start: time.Duration(*part.Start * float64(time.Second))}
+		if part.End != nil {
+			clip.End = time.Duration(*part.End * float64(time.Second))
+		}
+		clips = append(clips, clip)
+	}
+	return clips
+}
+
+// airDate returns the earliest release or upload date listed in the page's
+// context. The releasedEvent is a single event or a list of them, one for each
+// region.
+func (c *contextStruct) airDate() time.Time {
+	var events []releasedEvent
+	if err := json.Unmarshal(c.Released, &events); err != nil {
+		var event releasedEvent
+		if json.Unmarshal(c.Released, &event) == nil {
+			events = append(events, event)
+		}
+	}
+
+	dates := []string{c.DatePublished, c.UploadDate}
+	for _, event := range events {
+		dates = append(dates, event.StartDate)
+	}
+
+	var earliest time.Time
+	for _, date := range dates {
+		parsed, err := time.Parse(time.RFC3339, date)
+		if err != nil {
+			if parsed, err = time.Parse("2006-01-02", date); err != nil {
+				continue
+			}
+		}
+
+		if earliest.IsZero() || parsed.Before(earliest) {
+			earliest = parsed
+		}
+	}
+	return earliest
+}
+
+// NewEpisode creates an episode from its page URL.
+func NewEpisode(showURL string) *Episode {
+	return &Episode{
+		EpisodeURL: showURL,
+	}
+}
+
+// FetchInfo reads the episode's page to fill in its details and the URL of the
+// stream with subLang hardsubs, or no hardsubs if subLang is "none".
+func (e *Episode) FetchInfo(ctx context.Context, s *Session, subLang string) error {
+	subLang = strings.ReplaceAll(subLang, "-", "")
+	if subLang == "none" {
+		subLang = ""
+	}
+
+	res, err := s.Get(ctx, e.EpisodeURL)
+	if err != nil {
+		return fmt.Errorf("getting episode response: %w", err)
+	}
+
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("reading episode response: %w", err)
+	}
+
+	vilosResult := regexp.MustCompile(`vilos.config.media = (.*);\n`).FindSubmatch(body)
+	if vilosResult == nil {
+		return fmt.Errorf("parsing metadata regexp")
+	}
+
+	var config configStruct
+	if err = json.Unmarshal(vilosResult[1], &config); err != nil {
+		return fmt.Errorf("unmarshaling metadata regexp: %w", err)
+	}
+
+	// If this works it will return 4 integers, with the first two
+	// Being the index length of the full expression and the last
+	// Two being the length of the captured expression. We need the
+	// `{"@context":\[` part of the full expression, so we take
+	// The first int and the fourth, which will get the beginning
+	// Of the full expression but stop at the end of the matched
+	// expression, which will allow us to parse the result to a struct
+	contextResult := regexp.MustCompile(`{"@context":\[(.*)</script>`).FindSubmatchIndex(body)
+	if contextResult == nil {
+		return fmt.Errorf("parsing context regexp")
+	}
+
+	var context contextStruct
+	if err = json.Unmarshal(body[contextResult[0]:contextResult[3]], &context); err != nil {
+		return fmt.Errorf("unmarshaling context regexp: %w", err)
+	}
+
+	if context.Season.Number == "0" {
+		context.Season.Number = "1"
+	}
+
+	e.Title = config.Metadata.Title
+	e.Number = config.Metadata.Number
+	e.SeriesTitle = context.Series.Title
+	e.SeasonNumber = context.Season.Number
+	e.Streams = config.Streams
+	e.Subtitles = config.Subtitles
+	e.MediaID = string(config.Metadata.ID)
+	e.Description = config.Metadata.Description
+	e.AirDate = context.airDate()
+
+	if e.Description == "" {
+		e.Description = context.Description
+	}
+
+	e.SeriesID = string(config.Metadata.SeriesID)
+	e.SeasonTitle = context.Season.Title
+	e.ContentRating = ratingName(context.ContentRating)
+	e.Clips = context.clips()
+
+	// The config has the exact length, the page's context is rounded
+	if ms, err := strconv.ParseFloat(string(config.Metadata.Duration), 64); err == nil && ms > 0 {
+		e.Duration = time.Duration(ms * float64(time.Millisecond))
+	} else if e.Duration = parseISODuration(context.Duration); e.Duration == 0 {
+		e.Duration = parseISODuration(context.TimeRequired)
+	}
+
+	e.ThumbnailURL = config.Thumbnail.URL
+	if e.ThumbnailURL == "" {
+		e.ThumbnailURL = imageURL(context.Image)
+	}
+
+	// Two methods, hardsubs or no hardsubs
+	for _, stream := range config.Streams {
+		if stream.Format == "adaptive_hls" && stream.HardsubLang == subLang {
+			e.StreamURL = stream.URL
+			e.AudioLang = stream.AudioLang
+			break
+		}
+	}
+
+	if e.StreamURL == "" {
+		return fmt.Errorf("%w with language %q", ErrNoStream, subLang)
+	}
+	return nil
+}
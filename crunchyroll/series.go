@@ -0,0 +1,209 @@
+package crunchyroll
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// URLPattern matches a Crunchyroll series URL, with an optional episode.
+const URLPattern string = `https://www.crunchyroll.com/([a-z0-9-]+)/?([a-z0-9-]+)?`
+
+func getValues(node *html.Node, value, keyword string) (values []string, nodes []*html.Node) {
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			var exists bool
+			var data string
+
+			for _, attr := range n.Attr {
+				if attr.Key == "class" && strings.Contains(attr.Val, keyword) {
+					exists = true
+				} else if attr.Key == value {
+					data = attr.Val
+				}
+
+				if exists == true && data != "" {
+					values = append(values, data)
+					nodes = append(nodes, n)
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(node)
+	return
+}
+
+// Season is a group of episodes listed on a series page. Series that only have
+// one season have no season dividers, so their season has no title.
+type Season struct {
+	Title    string
+	Episodes []*Episode
+}
+
+func attribute(node *html.Node, key string) string {
+	for _, attr := range node.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// episodeLinks returns the episodes linked below node in the order they aired.
+// Only their URLs and the titles of the links are set.
+func episodeLinks(node *html.Node) []*Episode {
+	hrefs, links := getValues(node, "href", "titlefix episode")
+	episodes := []*Episode{}
+
+	for i := len(hrefs) - 1; i >= 0; i-- {
+		episode := NewEpisode("http://www.crunchyroll.com" + hrefs[i])
+		episode.Title = attribute(links[i], "title")
+		episodes = append(episodes, episode)
+	}
+	return episodes
+}
+
+// Seasons returns the seasons listed on a series page, including the dubbed
+// ones, in the order they are listed on the page.
+func (s *Session) Seasons(ctx context.Context, seriesURL string) ([]*Season, error) {
+	resp, err := s.Get(ctx, seriesURL)
+	if err != nil {
+		return nil, fmt.Errorf("getting series page: %w", err)
+	}
+
+	defer resp.Body.Close()
+	nodes, err := html.Parse(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("parsing series page: %w", err)
+	}
+
+	names, data := getValues(nodes, "title", "season-dropdown")
+	if len(names) == 0 { //It's a single season and doesn't have the season dividers
+		return []*Season{{Episodes: episodeLinks(nodes)}}, nil
+	}
+
+	seasons := []*Season{}
+	for i, name := range names {
+		seasons = append(seasons, &Season{
+			Title:    name,
+			Episodes: episodeLinks(data[i].Parent),
+		})
+	}
+	return seasons, nil
+}
+
+// IsSeries reports whether showURL links to a series rather than an episode.
+func IsSeries(showURL string) (bool, error) {
+	submatches := regexp.MustCompile(URLPattern).FindStringSubmatch(showURL)
+	if len(submatches) != 3 {
+		return false, fmt.Errorf("invalid crunchyroll url %q", showURL)
+	}
+
+	// If there is no extra parameter after the slash, then it is a series.
+	return submatches[2] == "", nil
+}
+
+// SeriesURL returns the url of the series that showURL links to, or belongs to
+// if it links to an episode.
+func SeriesURL(showURL string) (string, error) {
+	submatches := regexp.MustCompile(URLPattern).FindStringSubmatch(showURL)
+	if len(submatches) != 3 {
+		return "", fmt.Errorf("invalid crunchyroll url %q", showURL)
+	}
+	return "https://www.crunchyroll.com/" + submatches[1], nil
+}
+
+// Series holds the details listed on a series page.
+type Series struct {
+	URL         string
+	Title       string
+	Description string
+	PosterURL   string
+	BannerURL   string // Empty if the page only has the poster
+}
+
+// SeriesInfo reads the title, description and artwork of a series page. They
+// come from the page's meta tags, and the poster from its poster image.
+func (s *Session) SeriesInfo(ctx context.Context, seriesURL string) (*Series, error) {
+	resp, err := s.Get(ctx, seriesURL)
+	if err != nil {
+		return nil, fmt.Errorf("getting series page: %w", err)
+	}
+
+	defer resp.Body.Close()
+	nodes, err := html.Parse(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("parsing series page: %w", err)
+	}
+
+	series := &Series{URL: seriesURL}
+	var image string
+
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "meta" {
+			content := attribute(n, "content")
+			switch attribute(n, "property") + attribute(n, "name") {
+			case "og:title":
+				series.Title = content
+			case "og:description", "description":
+				if series.Description == "" {
+					series.Description = content
+				}
+			case "og:image":
+				image = content
+			}
+		} else if n.Type == html.ElementNode && n.Data == "img" && series.PosterURL == "" && strings.Contains(attribute(n, "class"), "poster") {
+			series.PosterURL = attribute(n, "src")
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(nodes)
+
+	if series.PosterURL == "" {
+		series.PosterURL = image
+	} else if image != series.PosterURL {
+		series.BannerURL = image
+	}
+	return series, nil
+}
+
+// Episodes returns the episodes of a series page in the order they aired, or
+// the single episode if showURL links to an episode. Only the episode URLs are
+// set, use Episode.FetchInfo to get the rest of their details.
+func (s *Session) Episodes(ctx context.Context, showURL string, dubbed bool) ([]*Episode, error) {
+	series, err := IsSeries(showURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if series == false {
+		return []*Episode{NewEpisode(showURL)}, nil
+	}
+
+	seasons, err := s.Seasons(ctx, showURL)
+	if err != nil {
+		return nil, err
+	}
+
+	episodes := []*Episode{}
+	for _, season := range seasons {
+		hasDubbedTitle := strings.Contains(season.Title, "Dubbed")
+
+		if season.Title == "" || (dubbed && hasDubbedTitle) || (!dubbed && !hasDubbedTitle) {
+			episodes = season.Episodes
+		}
+	}
+	return episodes, nil
+}
@@ -0,0 +1,311 @@
+// Package crunchyroll logs into Crunchyroll and looks up the series, episodes
+// and streams that can be downloaded.
+package crunchyroll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	uaList    = "https://raw.githubusercontent.com/cvandeplas/pystemon/master/user-agents.txt"
+	defaultUA = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_13_5) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/67.0.3396.87 Safari/537.36"
+)
+
+// ErrStalled is returned when reading a response body that hasn't received any
+// bytes for the session's stall timeout. It is a net.Error whose Timeout method
+// reports true.
+var ErrStalled error = stallError{}
+
+type stallError struct{}
+
+func (stallError) Error() string   { return "connection stalled" }
+func (stallError) Timeout() bool   { return true }
+func (stallError) Temporary() bool { return true }
+
+// ErrLoginFailed is returned by Login and Verify when the site doesn't show the
+// account as logged in.
+var ErrLoginFailed = errors.New("login failed, check the username and password")
+
+var siteURL, _ = url.Parse("https://www.crunchyroll.com/")
+
+// Session is an HTTP client that keeps the cookies of a Crunchyroll login.
+type Session struct {
+	Client       *http.Client
+	UserAgent    string
+	StallTimeout time.Duration
+}
+
+// NewSession creates a session where every request has to be answered within
+// timeout, and a response body that receives no bytes for stallTimeout fails
+// with ErrStalled. Bodies aren't bound by timeout itself since segments can
+// take a long time to read when the download speed is limited. Requests go
+// through proxy, or the proxy from the environment if it is nil. A random
+// User-Agent is picked from a public list when it can be fetched.
+func NewSession(ctx context.Context, timeout, stallTimeout time.Duration, proxy *url.URL) *Session {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+
+	client := &http.Client{Transport: transport}
+	client.Jar, _ = cookiejar.New(nil)
+	c := &Session{
+		Client:       client,
+		UserAgent:    defaultUA,
+		StallTimeout: stallTimeout,
+	}
+
+	resp, err := c.Get(ctx, uaList)
+	if err == nil {
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+
+		if err == nil {
+			userAgentParsed := strings.Split(string(body), "\n")
+			if len(userAgentParsed) > 0 {
+				c.UserAgent = userAgentParsed[rand.Intn(len(userAgentParsed))]
+			}
+		}
+	}
+
+	return c
+}
+
+// Cookies returns the cookies the session holds for Crunchyroll, so that a
+// login can be saved and restored with SetCookies later.
+func (c *Session) Cookies() []*http.Cookie {
+	return c.Client.Jar.Cookies(siteURL)
+}
+
+// SetCookies adds cookies for Crunchyroll to the session.
+func (c *Session) SetCookies(cookies []*http.Cookie) {
+	c.Client.Jar.SetCookies(siteURL, cookies)
+}
+
+// Get requests url with the session's cookies and User-Agent.
+func (c *Session) Get(ctx context.Context, url string) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	req.Header.Add("User-Agent", c.UserAgent)
+
+	res, err := c.Client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.Body = newStallReader(res.Body, c.StallTimeout, cancel)
+	return res, err
+}
+
+// stallReader cancels its request if a read gets no bytes for the stall
+// timeout. Only the time spent inside Read counts, so a caller that is slow to
+// read, such as one held back by a rate limit, doesn't stall the request.
+type stallReader struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+
+	lock    sync.Mutex
+	stalled bool
+}
+
+func newStallReader(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) *stallReader {
+	return &stallReader{
+		body:    body,
+		timeout: timeout,
+		cancel:  cancel,
+	}
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	if s.timeout > 0 {
+		if s.timer == nil {
+			s.timer = time.AfterFunc(s.timeout, func() {
+				s.lock.Lock()
+				s.stalled = true
+				s.lock.Unlock()
+				s.cancel()
+			})
+		} else {
+			s.timer.Reset(s.timeout)
+		}
+	}
+
+	n, err := s.body.Read(p)
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+
+	if err != nil && err != io.EOF {
+		s.lock.Lock()
+		if s.stalled {
+			err = ErrStalled
+		}
+		s.lock.Unlock()
+	}
+	return n, err
+}
+
+func (s *stallReader) Close() error {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	err := s.body.Close()
+	s.cancel()
+	return err
+}
+
+// Taken from https://godoc.org/golang.org/x/net/html#example-Parse
+func loopFindToken(n *html.Node) string {
+	if n.Type == html.ElementNode && n.Data == "input" {
+		var exists bool
+		var token string
+
+		for _, data := range n.Attr {
+			if data.Val == "login_form__token" {
+				exists = true
+			} else if data.Key == "value" {
+				token = data.Val
+			}
+		}
+
+		if exists == true && token != "" {
+			return token
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if token := loopFindToken(c); token != "" {
+			return token
+		}
+	}
+	return ""
+}
+
+// Login authenticates the session with a Crunchyroll account.
+func (c *Session) Login(ctx context.Context, user, pass string) error {
+	resp, err := c.Get(ctx, "https://www.crunchyroll.com/login")
+	if err != nil {
+		return fmt.Errorf("getting login page: %w", err)
+	}
+
+	defer resp.Body.Close()
+	nodes, err := html.Parse(resp.Body)
+	if err != nil {
+		return fmt.Errorf("parsing login response: %w", err)
+	}
+
+	token := loopFindToken(nodes)
+	if token == "" {
+		return fmt.Errorf("unable to find token")
+	}
+
+	body := url.Values{
+		"login_form[name]":         {user},
+		"login_form[password]":     {pass},
+		"login_form[redirect_url]": {"/"},
+		"login_form[_token]":       {token},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://www.crunchyroll.com/login", strings.NewReader(body.Encode()))
+	if err != nil {
+		return fmt.Errorf("creating authentication request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", c.UserAgent)
+
+	postResp, err := c.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting authentication request: %w", err)
+	}
+	postResp.Body.Close()
+	return c.Verify(ctx)
+}
+
+// Verify checks that the session is logged in, by looking for the account name
+// on the site's front page. It is how a saved session is found to have expired.
+func (c *Session) Verify(ctx context.Context) error {
+	resp, err := c.Get(ctx, siteURL.String())
+	if err != nil {
+		return fmt.Errorf("getting crunchyroll website: %w", err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("getting crunchyroll website: unexpected status %d", resp.StatusCode)
+	}
+
+	nodes, err := html.Parse(resp.Body)
+	if err != nil {
+		return fmt.Errorf("parsing session validation page: %w", err)
+	}
+
+	if loopFindUsername(nodes) == "" {
+		return ErrLoginFailed
+	}
+	return nil
+}
+
+// loopFindUsername returns the text of the li.username element of the page,
+// which holds the name of the logged in account.
+func loopFindUsername(n *html.Node) string {
+	if n.Type == html.ElementNode && n.Data == "li" {
+		for _, data := range n.Attr {
+			if data.Key == "class" && hasClass(data.Val, "username") {
+				return strings.TrimSpace(nodeText(n))
+			}
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if name := loopFindUsername(c); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+func hasClass(classes, class string) bool {
+	for _, field := range strings.Fields(classes) {
+		if field == class {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		text.WriteString(nodeText(c))
+	}
+	return text.String()
+}
@@ -0,0 +1,172 @@
+package crunchyroll
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/turtletowerz/m3u8"
+)
+
+// Qualities maps the shorthand qualities to their resolutions.
+var Qualities = map[string]string{
+	"240":  "428x240",
+	"360":  "640x360",
+	"480":  "848x480",
+	"720":  "1280x720",
+	"1080": "1920x1080",
+}
+
+func getAccurateQuality(variants []*m3u8.Variant, quality string) ([]string, *m3u8.Variant) {
+	qualities := map[int]*m3u8.Variant{}
+
+	for _, val := range variants {
+		if val.Resolution == nil {
+			continue
+		}
+
+		res, exists := qualities[val.Resolution.Width]
+		if exists == false || (exists == true && val.Bandwidth > res.Bandwidth) {
+			qualities[val.Resolution.Width] = val
+		}
+	}
+
+	var qualityStrings []string
+	var bestQualityIndex int
+
+	for width, variant := range qualities {
+		resolutionString := fmt.Sprintf("%dx%d", variant.Resolution.Width, variant.Resolution.Height)
+		qualityStrings = append(qualityStrings, resolutionString)
+
+		if (quality == "max" && width > bestQualityIndex) || (quality == "min" && (width < bestQualityIndex || bestQualityIndex == 0)) || quality == resolutionString {
+			bestQualityIndex = width
+		}
+	}
+
+	if bestVariant, exists := qualities[bestQualityIndex]; exists == true {
+		return qualityStrings, bestVariant
+	}
+	return qualityStrings, nil
+}
+
+// Variants returns the variants of the master playlist at url, from the
+// highest bandwidth to the lowest.
+func Variants(ctx context.Context, s *Session, url string) ([]*m3u8.Variant, error) {
+	resp, err := s.Get(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("getting video url: %w", err)
+	}
+
+	defer resp.Body.Close()
+	playlist, listType, err := m3u8.DecodeFrom(resp.Body, true)
+	if err != nil {
+		return nil, fmt.Errorf("decoding m3u8 response: %w", err)
+	}
+
+	if listType != m3u8.MASTER {
+		return nil, fmt.Errorf("not a master playlist")
+	}
+
+	variants := playlist.(*m3u8.MasterPlaylist).Variants
+	sort.SliceStable(variants, func(i, j int) bool {
+		return variants[i].Bandwidth > variants[j].Bandwidth
+	})
+	return variants, nil
+}
+
+// BestVariant picks the variant of a master playlist closest to quality, which
+// is a "WidthxHeight" resolution, a shorthand from Qualities, "max" or "min".
+// The resolutions that are available are returned as well, even when there's
+// no match for quality.
+func BestVariant(ctx context.Context, s *Session, url, quality string) (*m3u8.Variant, []string, error) {
+	if val, exists := Qualities[quality]; exists == true {
+		quality = val
+	}
+
+	variants, err := Variants(ctx, s, url)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	qualities, bestQuality := getAccurateQuality(variants, quality)
+	if bestQuality == nil {
+		return nil, qualities, fmt.Errorf("no stream of quality %q\nAvaliable qualities: %s", quality, strings.Join(qualities, ", "))
+	}
+	return bestQuality, qualities, nil
+}
+
+// transportStream reports whether the segments of the media playlist at url
+// are MPEG-TS. Audio renditions can instead be packed audio, such as raw AAC,
+// which the downloader can't merge.
+func transportStream(ctx context.Context, s *Session, url string) bool {
+	resp, err := s.Get(ctx, url)
+	if err != nil {
+		return false
+	}
+
+	defer resp.Body.Close()
+	playlist, listType, err := m3u8.DecodeFrom(resp.Body, true)
+	if err != nil || listType != m3u8.MEDIA {
+		return false
+	}
+
+	for _, segment := range playlist.(*m3u8.MediaPlaylist).Segments {
+		if segment == nil {
+			continue
+		}
+
+		parsed, err := resp.Request.URL.Parse(segment.URI)
+		return err == nil && strings.EqualFold(path.Ext(parsed.Path), ".ts")
+	}
+	return false
+}
+
+// AudioVariant picks the smallest stream of a master playlist that has the
+// audio of an episode. A separate audio rendition is used if the playlist has
+// one whose segments are MPEG-TS, and otherwise the variant with the lowest
+// bandwidth. The bandwidth of a rendition isn't known, so it is zero.
+func AudioVariant(ctx context.Context, s *Session, masterURL string) (*m3u8.Variant, error) {
+	variants, err := Variants(ctx, s, masterURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("no streams in master playlist")
+	}
+
+	base, err := url.Parse(masterURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing master playlist url: %w", err)
+	}
+
+	checked := map[string]bool{}
+	for _, variant := range variants {
+		for _, alt := range variant.Alternatives {
+			if alt == nil || alt.Type != "AUDIO" || alt.URI == "" {
+				continue
+			}
+
+			renditionURL, err := base.Parse(alt.URI)
+			if err != nil {
+				return nil, fmt.Errorf("parsing audio rendition uri: %w", err)
+			}
+
+			uri := renditionURL.String()
+			if checked[uri] {
+				continue
+			}
+			checked[uri] = true
+
+			if transportStream(ctx, s, uri) {
+				return &m3u8.Variant{URI: uri}, nil
+			}
+		}
+	}
+
+	// The variants are sorted from the highest bandwidth
+	return variants[len(variants)-1], nil
+}
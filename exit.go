@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/turtletowerz/crunchyrip/hls"
+)
+
+// Exit codes, so that scripts can tell why a run failed.
+const (
+	exitOK          int = 0
+	exitFailure     int = 1 // Any error without a more specific code
+	exitUsage       int = 2 // Invalid flags, arguments or config file
+	exitAuth        int = 3 // Logging in failed, or there is no saved session or it has expired
+	exitNotFound    int = 4 // The url is invalid or has no episodes to download
+	exitPartial     int = 5 // Some episodes failed while others were downloaded
+	exitTotal       int = 6 // Every episode failed
+	exitNoFFmpeg    int = 7 // ffmpeg couldn't be found
+	exitInterrupted int = 130
+)
+
+// codeError is an error that ends the run with a specific exit code.
+type codeError struct {
+	code int
+	err  error
+}
+
+func (e *codeError) Error() string { return e.err.Error() }
+func (e *codeError) Unwrap() error { return e.err }
+
+// withCode attaches an exit code to err, unless it is nil or already has one.
+func withCode(code int, err error) error {
+	var coded *codeError
+	if err == nil || errors.As(err, &coded) {
+		return err
+	}
+	return &codeError{code, err}
+}
+
+// exitCode returns the exit code for an error that ended a command.
+func exitCode(err error) int {
+	var coded *codeError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case errors.Is(err, hls.ErrNoFFmpeg):
+		return exitNoFFmpeg
+	}
+	return exitFailure
+}
+
+// exitWith logs the error that ended a command and returns its exit code.
+func exitWith(err error) int {
+	logError(err)
+	return exitCode(err)
+}
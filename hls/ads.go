@@ -0,0 +1,140 @@
+package hls
+
+import (
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/turtletowerz/m3u8"
+)
+
+// maxAdDuration is the longest a part between discontinuities can be for its
+// length alone to mark it as an ad.
+const maxAdDuration time.Duration = 60 * time.Second
+
+// adPattern matches the host or path of segments served by an ad server.
+var adPattern = regexp.MustCompile(`(?i)(^|[/._-])(ads?|adserver|adverts?|advertising|preroll|midroll|doubleclick|freewheel|fwmrm|imasdk)([/._-]|$)`)
+
+// AdBreak is a part of the playlist, between discontinuities, that was
+// detected as an ad. Reason is why: "scte" for an SCTE-35 tag, "host" for
+// segments from a different host than the episode, "url" for segments whose
+// url looks like an ad server's, or "duration" for a part that is too short to
+// be the episode. A break found by its duration alone may as well be a short
+// part of the episode, such as a preview, so it is only reported.
+type AdBreak struct {
+	Start    time.Duration // Where the break starts in the full playlist
+	Duration time.Duration
+	Segments int
+	Reason   string
+}
+
+// Certain reports whether something other than its duration marks the break
+// as an ad. StripAds only leaves out the breaks that are certain.
+func (b AdBreak) Certain() bool {
+	return b.Reason != "duration"
+}
+
+func segmentsDuration(segments []*m3u8.MediaSegment) time.Duration {
+	var seconds float64
+	for _, segment := range segments {
+		seconds += segment.Duration
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// splitDiscontinuities splits the segments into the parts separated by an
+// EXT-X-DISCONTINUITY.
+func splitDiscontinuities(segments []*m3u8.MediaSegment) [][]*m3u8.MediaSegment {
+	var parts [][]*m3u8.MediaSegment
+	for _, segment := range segments {
+		if len(parts) == 0 || segment.Discontinuity {
+			parts = append(parts, nil)
+		}
+		parts[len(parts)-1] = append(parts[len(parts)-1], segment)
+	}
+	return parts
+}
+
+// adReason returns why a part of the playlist is an ad, or an empty string if
+// it isn't one. mainHost is the host of the episode's own segments.
+func adReason(part []*m3u8.MediaSegment, mainHost string) string {
+	reason := ""
+	for _, segment := range part {
+		parsed, err := url.Parse(segment.URI)
+		switch {
+		case segment.SCTE != nil:
+			return "scte"
+		case err == nil && parsed.Host != mainHost:
+			return "host"
+		case err == nil && adPattern.MatchString(parsed.Host+parsed.Path):
+			reason = "url"
+		}
+	}
+
+	if reason == "" && segmentsDuration(part) <= maxAdDuration {
+		reason = "duration"
+	}
+	return reason
+}
+
+// findAds returns the ad breaks in segments, along with the segments that are
+// left without the certain ones. The longest part is taken to be the episode
+// and is never an ad, so a playlist without discontinuities has no ads.
+func findAds(segments []*m3u8.MediaSegment) ([]AdBreak, []*m3u8.MediaSegment) {
+	parts := splitDiscontinuities(segments)
+	if len(parts) < 2 {
+		return nil, segments
+	}
+
+	longest := 0
+	for i, part := range parts {
+		if segmentsDuration(part) > segmentsDuration(parts[longest]) {
+			longest = i
+		}
+	}
+
+	var mainHost string
+	if parsed, err := url.Parse(parts[longest][0].URI); err == nil {
+		mainHost = parsed.Host
+	}
+
+	var breaks []AdBreak
+	var kept []*m3u8.MediaSegment
+	var start time.Duration
+
+	for i, part := range parts {
+		duration := segmentsDuration(part)
+		adBreak := AdBreak{Start: start, Duration: duration, Segments: len(part), Reason: adReason(part, mainHost)}
+		if i != longest && adBreak.Reason != "" {
+			breaks = append(breaks, adBreak)
+		}
+
+		if i == longest || adBreak.Reason == "" || adBreak.Certain() == false {
+			kept = append(kept, part...)
+		}
+		start += duration
+	}
+	return breaks, kept
+}
+
+// AdBreaks returns the parts of the playlist that look like ads.
+func (d *Downloader) AdBreaks() []AdBreak {
+	breaks, _ := findAds(d.all)
+	return breaks
+}
+
+// StripAds leaves the parts of the playlist that are certainly ads out of the
+// download, and returns them. It must be called before Download.
+func (d *Downloader) StripAds() []AdBreak {
+	breaks, kept := findAds(d.all)
+	d.all = kept
+	d.segmentCount = len(kept)
+
+	var stripped []AdBreak
+	for _, adBreak := range breaks {
+		if adBreak.Certain() {
+			stripped = append(stripped, adBreak)
+		}
+	}
+	return stripped
+}
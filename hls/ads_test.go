@@ -0,0 +1,102 @@
+package hls
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/turtletowerz/m3u8"
+)
+
+// part describes the segments between two discontinuities of a test playlist.
+type part struct {
+	base     string // Url that the segment numbers are added to
+	segments int
+	length   float64 // Seconds of each segment
+	scte     bool    // The first segment has an SCTE-35 tag
+}
+
+// playlist returns the segments of parts, with a discontinuity between each.
+func playlist(parts ...part) []*m3u8.MediaSegment {
+	var segments []*m3u8.MediaSegment
+	for i, p := range parts {
+		for j := 0; j < p.segments; j++ {
+			segment := &m3u8.MediaSegment{
+				SeqId:         uint64(len(segments)),
+				URI:           fmt.Sprintf("%s/%d.ts", p.base, len(segments)),
+				Duration:      p.length,
+				Discontinuity: i > 0 && j == 0,
+			}
+
+			if p.scte && j == 0 {
+				segment.SCTE = &m3u8.SCTE{Cue: "/DAlAAAAAAAAAP/wFAUAAAABf+/+AAAAAH4AUmXAAAEAAAAAhJL6Ag=="}
+			}
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+func TestFindAds(t *testing.T) {
+	const main = "https://v.vrv.co/evs/assets/episode"
+
+	tests := []struct {
+		name   string
+		parts  []part
+		breaks []AdBreak
+		kept   int
+	}{
+		{
+			name:  "no discontinuities",
+			parts: []part{{main, 100, 10, false}},
+			kept:  100,
+		},
+		{
+			name:   "preroll from another host",
+			parts:  []part{{"https://cdn.example.com/spot", 3, 10, false}, {main, 100, 10, false}},
+			breaks: []AdBreak{{Start: 0, Duration: 30 * time.Second, Segments: 3, Reason: "host"}},
+			kept:   100,
+		},
+		{
+			name:   "midroll with scte",
+			parts:  []part{{main, 50, 10, false}, {main, 9, 10, true}, {main, 50, 10, false}},
+			breaks: []AdBreak{{Start: 500 * time.Second, Duration: 90 * time.Second, Segments: 9, Reason: "scte"}},
+			kept:   100,
+		},
+		{
+			name:   "ad server url",
+			parts:  []part{{main, 50, 10, false}, {"https://v.vrv.co/evs/midroll", 2, 15, false}, {main, 50, 10, false}},
+			breaks: []AdBreak{{Start: 500 * time.Second, Duration: 30 * time.Second, Segments: 2, Reason: "url"}},
+			kept:   100,
+		},
+		{
+			name:   "short part is only reported",
+			parts:  []part{{main, 50, 10, false}, {main, 3, 10, false}, {main, 50, 10, false}},
+			breaks: []AdBreak{{Start: 500 * time.Second, Duration: 30 * time.Second, Segments: 3, Reason: "duration"}},
+			kept:   103,
+		},
+		{
+			name:  "long part without markers",
+			parts: []part{{main, 50, 10, false}, {main, 10, 10, false}, {main, 50, 10, false}},
+			kept:  110,
+		},
+		{
+			name:   "longest part is the episode",
+			parts:  []part{{main, 5, 10, false}, {"https://cdn.example.com/episode", 100, 10, false}},
+			breaks: []AdBreak{{Start: 0, Duration: 50 * time.Second, Segments: 5, Reason: "host"}},
+			kept:   100,
+		},
+	}
+
+	for _, test := range tests {
+		breaks, kept := findAds(playlist(test.parts...))
+		if reflect.DeepEqual(breaks, test.breaks) == false {
+			t.Errorf("%s: breaks = %+v, want %+v", test.name, breaks, test.breaks)
+		}
+
+		if len(kept) != test.kept {
+			t.Errorf("%s: kept %d segments, want %d", test.name, len(kept), test.kept)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package hls
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Chapter is a named part of the output, from Start up to End.
+type Chapter struct {
+	Title string
+	Start time.Duration
+	End   time.Duration
+}
+
+// Discontinuities returns the times where the playlist has an
+// EXT-X-DISCONTINUITY, which usually separate the parts of an episode.
+func (d *Downloader) Discontinuities() []time.Duration {
+	var times []time.Duration
+	var seconds float64
+
+	for _, segment := range d.all {
+		if segment.Discontinuity && seconds > 0 {
+			times = append(times, time.Duration(seconds*float64(time.Second)))
+		}
+		seconds += segment.Duration
+	}
+	return times
+}
+
+var ffmetadataEscaper = strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n")
+
+// writeFFMetadata writes chapters to filename in ffmpeg's metadata format.
+func writeFFMetadata(filename string, chapters []Chapter) error {
+	var builder strings.Builder
+	builder.WriteString(";FFMETADATA1\n")
+
+	for _, chapter := range chapters {
+		fmt.Fprintf(&builder, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			chapter.Start.Milliseconds(), chapter.End.Milliseconds(), ffmetadataEscaper.Replace(chapter.Title))
+	}
+
+	if err := ioutil.WriteFile(filename, []byte(builder.String()), 0644); err != nil {
+		return fmt.Errorf("writing chapters: %w", err)
+	}
+	return nil
+}
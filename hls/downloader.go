@@ -0,0 +1,508 @@
+// Package hls downloads HLS media playlists, decrypting and merging their
+// segments into a single transport stream.
+package hls
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/turtletowerz/m3u8"
+)
+
+const (
+	defaultChannels int    = 25 // Maximum number of connections when tuning adaptively
+	aesMethod       string = "AES-128"
+
+	maxAttempts   int           = 6 // Requests made for a segment before the download fails
+	retryDelay    time.Duration = 500 * time.Millisecond
+	maxRetryDelay time.Duration = 30 * time.Second
+)
+
+// Client makes the requests for playlists, keys and segments.
+type Client interface {
+	Get(ctx context.Context, url string) (*http.Response, error)
+}
+
+// StatusError is returned when a segment or key request gets a response other
+// than 200 OK.
+type StatusError struct {
+	Code int
+	URL  string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d from %q", e.Code, e.URL)
+}
+
+// Options configures a Downloader.
+type Options struct {
+	// Name is the base name of the files written to Dir: the merged Name.ts
+	// and the Name-ts directory that holds the segments while downloading.
+	Name string
+	Dir  string
+
+	// Scheduler limits the connections and bandwidth used for segments. It
+	// can be shared between downloaders, and if nil an adaptive scheduler
+	// without a rate limit is created for the downloader and run while it
+	// downloads. A scheduler that is given must be started by the caller.
+	Scheduler *Scheduler
+
+	// Reporter receives the events of the download, if set.
+	Reporter Reporter
+}
+
+// Downloader fetches the segments of a single media playlist.
+type Downloader struct {
+	lock         sync.Mutex
+	all          []*m3u8.MediaSegment
+	segments     []*m3u8.MediaSegment
+	segmentCount int
+	completed    int
+	output       string
+	source       string
+	storage      string
+	channelCount int
+	client       Client
+	scheduler    *Scheduler
+	ownScheduler bool // The scheduler was created for this downloader, so Download runs it
+	options      Options
+}
+
+func (d *Downloader) report(event Event) {
+	event.Name = d.options.Name
+	Report(d.options.Reporter, event)
+}
+
+// sequence returns the sequence number of segment for Event.Segment.
+func sequence(segment *m3u8.MediaSegment) *uint64 {
+	id := segment.SeqId
+	return &id
+}
+
+func getKey(ctx context.Context, client Client, baseURL *url.URL, keyPath string) (string, error) {
+	var keyURL string
+
+	if strings.HasPrefix(keyPath, "http") {
+		keyURL = keyPath
+	} else {
+		result, err := baseURL.Parse(keyPath)
+		if err != nil {
+			return "", fmt.Errorf("parsing new key url: %w", err)
+		}
+		keyURL = result.String()
+	}
+
+	resp, err := client.Get(ctx, keyURL)
+	if err != nil {
+		return "", fmt.Errorf("getting key url: %w", err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("getting key url: %w", &StatusError{Code: resp.StatusCode, URL: keyURL})
+	}
+
+	keyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("getting key url: %w", err)
+	}
+	return string(keyBytes), nil
+}
+
+// New fetches the media playlist at m3u8URL along with its decryption keys.
+func New(ctx context.Context, client Client, m3u8URL string, options Options) (*Downloader, error) {
+	parsedURL, err := url.Parse(m3u8URL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing m3u8 url: %w", err)
+	}
+
+	resp, err := client.Get(ctx, parsedURL.String())
+	if err != nil {
+		return nil, fmt.Errorf("getting m3u8 url: %w", err)
+	}
+
+	defer resp.Body.Close()
+	playlist, listType, err := m3u8.DecodeFrom(resp.Body, true)
+	if err != nil {
+		return nil, fmt.Errorf("parsing m3u8 url: %w", err)
+	}
+
+	if listType != m3u8.MEDIA {
+		return nil, fmt.Errorf("bad list type (must be MEDIA)")
+	}
+
+	mediaPlaylist := playlist.(*m3u8.MediaPlaylist)
+	segCount := int(mediaPlaylist.Count())
+	mediaSegments := make([]*m3u8.MediaSegment, segCount)
+
+	if mediaPlaylist.Key != nil && mediaPlaylist.Key.Method == aesMethod {
+		keyString, err := getKey(ctx, client, parsedURL, mediaPlaylist.Key.URI)
+		if err != nil {
+			return nil, fmt.Errorf("getting playlist key: %w", err)
+		}
+		mediaPlaylist.Key.URI = keyString
+	}
+
+	for i, segment := range mediaPlaylist.Segments {
+		if i == segCount {
+			break
+		}
+
+		if strings.HasPrefix(segment.URI, "http") == false {
+			newSegURL, err := parsedURL.Parse(segment.URI)
+			if err != nil {
+				return nil, fmt.Errorf("parsing segment uri: %w", err)
+			}
+			segment.URI = newSegURL.String()
+		}
+
+		if segment.Key != nil && segment.Key.Method == aesMethod {
+			segKey, err := getKey(ctx, client, parsedURL, segment.Key.URI)
+			if err != nil {
+				return nil, fmt.Errorf("getting segment %d key: %w", segment.SeqId, err)
+			}
+			segment.Key.URI = segKey
+		} else if mediaPlaylist.Key != nil && mediaPlaylist.Key.Method == aesMethod {
+			segment.Key = mediaPlaylist.Key
+		}
+		mediaSegments[i] = segment
+	}
+
+	scheduler := options.Scheduler
+	ownScheduler := scheduler == nil
+	if ownScheduler {
+		scheduler = NewScheduler(0, nil)
+	}
+
+	download := &Downloader{
+		segmentCount: segCount,
+		all:          mediaSegments,
+		output:       filepath.Join(options.Dir, options.Name+".ts"),
+		source:       parsedURL.Path,
+		storage:      filepath.Join(options.Dir, options.Name+"-ts"),
+		channelCount: scheduler.max,
+		client:       client,
+		scheduler:    scheduler,
+		ownScheduler: ownScheduler,
+		options:      options,
+	}
+	return download, nil
+}
+
+// Output returns the path of the merged transport stream.
+func (d *Downloader) Output() string {
+	return d.output
+}
+
+// Duration returns the total duration of the playlist's segments.
+func (d *Downloader) Duration() time.Duration {
+	var seconds float64
+	for _, segment := range d.all {
+		seconds += segment.Duration
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// Section leaves every segment that doesn't overlap the time from start up to
+// end out of the download, or up to the last segment if end is zero. It
+// returns where the first segment that is kept starts, which is where the
+// output begins, and must be called before Download.
+func (d *Downloader) Section(start, end time.Duration) (time.Duration, error) {
+	var kept []*m3u8.MediaSegment
+	var offset, position time.Duration
+
+	for _, segment := range d.all {
+		length := time.Duration(segment.Duration * float64(time.Second))
+		if position+length > start && (end == 0 || position < end) {
+			if len(kept) == 0 {
+				offset = position
+			}
+			kept = append(kept, segment)
+		}
+		position += length
+	}
+
+	if len(kept) == 0 {
+		return 0, fmt.Errorf("section starts after the end of the stream (%s)", position.Round(time.Second))
+	}
+
+	d.all = kept
+	d.segmentCount = len(kept)
+	return offset, nil
+}
+
+func (d *Downloader) segmentDone(segment *m3u8.MediaSegment, bytes int, duration time.Duration) {
+	d.lock.Lock()
+	d.completed = d.completed + 1
+	completed := d.completed
+	d.lock.Unlock()
+
+	d.report(Event{
+		Type:      SegmentDone,
+		Segment:   sequence(segment),
+		Completed: completed,
+		Total:     d.segmentCount,
+		Bytes:     int64(bytes),
+		Duration:  duration,
+	})
+}
+
+// prepareStorage makes sure the segment directory exists and queues every
+// segment that isn't in it yet. Segments left over from an interrupted run of
+// the same stream are kept, so the download picks up where it stopped.
+func (d *Downloader) prepareStorage() error {
+	sourceFile := filepath.Join(d.storage, "source")
+	if source, err := ioutil.ReadFile(sourceFile); err != nil || string(source) != d.source {
+		os.RemoveAll(d.storage)
+	}
+
+	if err := os.MkdirAll(d.storage, os.ModePerm); err != nil {
+		return fmt.Errorf("creating segment directory: %w", err)
+	}
+
+	if err := ioutil.WriteFile(sourceFile, []byte(d.source), 0644); err != nil {
+		return fmt.Errorf("writing segment source: %w", err)
+	}
+
+	d.segments = nil
+	d.completed = 0
+	for _, segment := range d.all {
+		if _, err := os.Stat(d.segmentPath(segment)); err == nil {
+			d.completed++
+			continue
+		}
+		d.segments = append(d.segments, segment)
+	}
+
+	if d.completed > 0 {
+		d.report(Event{Type: Resumed, Completed: d.completed, Total: d.segmentCount})
+	}
+	return nil
+}
+
+// Download fetches every segment and merges them into Output. If ctx is
+// cancelled the segments downloaded so far are kept, and the next Download of
+// the same stream with the same Options resumes from them. A segment that
+// can't be fetched after several attempts, or that the server answers with a
+// 4xx other than 429, fails the download with its last error.
+func (d *Downloader) Download(ctx context.Context) error {
+	if err := d.prepareStorage(); err != nil {
+		return err
+	}
+
+	if d.ownScheduler {
+		d.scheduler.Start()
+		defer d.scheduler.Stop()
+	}
+
+	workerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var failed error
+
+	for i := 0; i < d.channelCount; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for workerCtx.Err() == nil {
+				segment, done := d.next()
+
+				if done {
+					break
+				}
+
+				if err := d.fetch(workerCtx, segment); err != nil {
+					d.lock.Lock()
+					if failed == nil {
+						failed = err
+					}
+					d.lock.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if failed != nil {
+		return failed
+	}
+
+	if err := d.merge(); err != nil {
+		return fmt.Errorf("merging files: %w", err)
+	}
+	os.RemoveAll(d.storage)
+	return nil
+}
+
+// fetch downloads a segment, retrying failed requests with a longer wait after
+// each one. It returns nil without the segment if ctx is cancelled.
+func (d *Downloader) fetch(ctx context.Context, segment *m3u8.MediaSegment) error {
+	delay := retryDelay
+
+	for attempt := 1; ; attempt++ {
+		d.scheduler.acquire()
+		err := d.downloadSegment(ctx, segment)
+		d.scheduler.release()
+
+		if err == nil || ctx.Err() != nil {
+			return nil
+		}
+
+		d.scheduler.fail(err)
+		if attempt == maxAttempts || isPermanent(err) {
+			return fmt.Errorf("downloading segment %d: %w", segment.SeqId, err)
+		}
+		d.report(Event{Type: SegmentRetry, Segment: sequence(segment), Error: err.Error()})
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// isPermanent reports whether err is a 4xx response that retrying won't fix,
+// which is any of them except 429.
+func isPermanent(err error) bool {
+	var status *StatusError
+	return errors.As(err, &status) && status.Code >= 400 && status.Code < 500 && status.Code != http.StatusTooManyRequests
+}
+
+func (d *Downloader) next() (*m3u8.MediaSegment, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if len(d.segments) == 0 {
+		return nil, true
+	}
+	segment := d.segments[0]
+	d.segments = d.segments[1:]
+	return segment, false
+}
+
+func (d *Downloader) merge() error {
+	file, err := os.Create(d.output)
+	if err != nil {
+		return fmt.Errorf("creating final file: %w", err)
+	}
+
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+
+	for _, segment := range d.all {
+		fileBytes, err := ioutil.ReadFile(d.segmentPath(segment))
+		if err != nil {
+			if err, ok := err.(*os.PathError); ok == false {
+				d.report(Event{Type: Warning, Segment: sequence(segment), Error: fmt.Sprintf("reading segment: %v", err)})
+			}
+			continue
+		}
+
+		_, err = writer.Write(fileBytes)
+		if err != nil {
+			d.report(Event{Type: Warning, Segment: sequence(segment), Error: fmt.Sprintf("writing segment: %v", err)})
+			continue
+		}
+	}
+	writer.Flush()
+	return nil
+}
+
+func (d *Downloader) segmentPath(segment *m3u8.MediaSegment) string {
+	return filepath.Join(d.storage, strconv.FormatUint(segment.SeqId, 10)+".ts")
+}
+
+func (d *Downloader) downloadSegment(ctx context.Context, segment *m3u8.MediaSegment) error {
+	start := time.Now()
+	resp, err := d.client.Get(ctx, segment.URI)
+	if err != nil {
+		return fmt.Errorf("getting segment response: %w", err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("getting segment response: %w", &StatusError{Code: resp.StatusCode, URL: segment.URI})
+	}
+
+	respBytes, err := ioutil.ReadAll(d.scheduler.limiter.Reader(ctx, resp.Body))
+	if err != nil {
+		return fmt.Errorf("reading segment response: %w", err)
+	}
+
+	size := len(respBytes)
+	d.scheduler.record(size)
+
+	// This method and shorter and looks like it works fine If this ever fails...
+	// https://github.com/Greyh4t/m3u8-Downloader-Go/blob/master/main.go#L214
+	if segment.Key != nil && segment.Key.Method == aesMethod {
+		aesBlock, err := aes.NewCipher([]byte(segment.Key.URI))
+		if err != nil {
+			return fmt.Errorf("creating aes cipher: %w", err)
+		}
+
+		iv := []byte(segment.Key.IV)
+		if len(iv) == 0 {
+			iv = []byte(segment.Key.URI)
+		}
+		blockMode := cipher.NewCBCDecrypter(aesBlock, iv[:aesBlock.BlockSize()])
+		origData := make([]byte, len(respBytes))
+		blockMode.CryptBlocks(origData, respBytes)
+
+		length := len(origData)
+		respBytes = origData[:(length - int(origData[length-1]))]
+	}
+
+	// Credits to github.com/oopsguy/m3u8 for this
+	// https://en.wikipedia.org/wiki/MPEG_transport_stream
+	// Some TS files do not start with SyncByte 0x47, they can not be played after merging,
+	// Need to remove the bytes before the SyncByte 0x47(71).
+	syncByte := uint8(71) //0x47
+	for j := 0; j < len(respBytes); j++ {
+		if respBytes[j] == syncByte {
+			respBytes = respBytes[j:]
+			break
+		}
+	}
+
+	// Segments are written to a temporary file and renamed once complete, so an
+	// interrupted download never leaves a partial segment behind to resume from
+	partPath := d.segmentPath(segment) + ".part"
+	if err := ioutil.WriteFile(partPath, respBytes, 0644); err != nil {
+		return fmt.Errorf("writing bytes to file: %w", err)
+	}
+
+	if err := os.Rename(partPath, d.segmentPath(segment)); err != nil {
+		return fmt.Errorf("renaming segment file: %w", err)
+	}
+
+	d.segmentDone(segment, size, time.Since(start))
+	return nil
+}
@@ -0,0 +1,55 @@
+package hls
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSection(t *testing.T) {
+	const main = "https://v.vrv.co/evs/assets/episode"
+
+	tests := []struct {
+		name       string
+		start, end time.Duration
+		offset     time.Duration
+		first      uint64 // SeqId of the first segment kept
+		count      int
+		err        bool
+	}{
+		{name: "whole stream", start: 0, end: 0, offset: 0, first: 0, count: 10},
+		{name: "rest of the stream", start: 25 * time.Second, end: 0, offset: 20 * time.Second, first: 2, count: 8},
+		{name: "inside one segment", start: 31 * time.Second, end: 39 * time.Second, offset: 30 * time.Second, first: 3, count: 1},
+		{name: "on segment boundaries", start: 20 * time.Second, end: 40 * time.Second, offset: 20 * time.Second, first: 2, count: 2},
+		{name: "across segments", start: 15 * time.Second, end: 45 * time.Second, offset: 10 * time.Second, first: 1, count: 4},
+		{name: "end after the stream", start: 90 * time.Second, end: 200 * time.Second, offset: 90 * time.Second, first: 9, count: 1},
+		{name: "start after the stream", start: 100 * time.Second, end: 0, err: true},
+	}
+
+	for _, test := range tests {
+		d := &Downloader{all: playlist(part{main, 10, 10, false})}
+		d.segmentCount = len(d.all)
+
+		offset, err := d.Section(test.start, test.end)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: Section(%s, %s) kept %d segments, want an error", test.name, test.start, test.end, len(d.all))
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: Section(%s, %s) returned error: %v", test.name, test.start, test.end, err)
+			continue
+		}
+
+		if offset != test.offset {
+			t.Errorf("%s: offset = %s, want %s", test.name, offset, test.offset)
+		}
+
+		if len(d.all) != test.count || d.segmentCount != test.count {
+			t.Errorf("%s: kept %d segments (count %d), want %d", test.name, len(d.all), d.segmentCount, test.count)
+		} else if d.all[0].SeqId != test.first {
+			t.Errorf("%s: first segment = %d, want %d", test.name, d.all[0].SeqId, test.first)
+		}
+	}
+}
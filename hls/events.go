@@ -0,0 +1,108 @@
+package hls
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// EventType identifies what an Event reports.
+type EventType string
+
+// Events sent by a Downloader or Scheduler, and the episode events that
+// programs using the package can send to the same Reporter.
+const (
+	EpisodeStarted     EventType = "episode_started"
+	SegmentDone        EventType = "segment_done"
+	SegmentRetry       EventType = "segment_retry"
+	Resumed            EventType = "resumed"
+	Warning            EventType = "warning"
+	RemuxStarted       EventType = "remux_started"
+	Finished           EventType = "finished"
+	Failed             EventType = "failed"
+	ConnectionsChanged EventType = "connections_changed"
+	AdBreaksFound      EventType = "ad_breaks_found"
+	TranscodeProgress  EventType = "transcode_progress"
+)
+
+// Event describes something that happened while downloading. Name is the
+// Options.Name of the download it belongs to, and only the fields that make
+// sense for its Type are set. Segment is the sequence number of the segment an
+// event is about, and nil for events that aren't about one. Bytes and Duration
+// are the size of a segment and the time it took for SegmentDone, while
+// ConnectionsChanged sets Bytes to the throughput in bytes per second that led
+// to the change. AdBreaksFound sets Total to the number of ad breaks,
+// Completed to how many of them were stripped and Duration to their length
+// together. TranscodeProgress sets Completed to the seconds that were encoded
+// and Total to the seconds of the whole file. In JSON, Duration is a number of
+// nanoseconds.
+type Event struct {
+	Type        EventType     `json:"type"`
+	Time        time.Time     `json:"time"`
+	Name        string        `json:"name,omitempty"`
+	Title       string        `json:"title,omitempty"`
+	Segment     *uint64       `json:"segment,omitempty"`
+	Completed   int           `json:"completed,omitempty"`
+	Total       int           `json:"total,omitempty"`
+	Bytes       int64         `json:"bytes,omitempty"`
+	Duration    time.Duration `json:"duration,omitempty"`
+	Connections int           `json:"connections,omitempty"`
+	Path        string        `json:"path,omitempty"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// Reporter receives the events of one or more downloads. Report may be called
+// from several goroutines at once.
+type Reporter interface {
+	Report(event Event)
+}
+
+// ReporterFunc lets an ordinary function be used as a Reporter.
+type ReporterFunc func(event Event)
+
+// Report calls f(event).
+func (f ReporterFunc) Report(event Event) {
+	f(event)
+}
+
+// Reporters sends every event to each of its reporters in order.
+type Reporters []Reporter
+
+// Report sends event to every reporter.
+func (r Reporters) Report(event Event) {
+	for _, reporter := range r {
+		reporter.Report(event)
+	}
+}
+
+// JSONReporter writes each event as a single line of JSON.
+type JSONReporter struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewJSONReporter creates a reporter that writes JSON lines to w.
+func NewJSONReporter(w io.Writer) *JSONReporter {
+	return &JSONReporter{encoder: json.NewEncoder(w)}
+}
+
+// Report writes event to the underlying writer.
+func (j *JSONReporter) Report(event Event) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.encoder.Encode(event)
+}
+
+// Report sends event to reporter, filling in its time if it isn't set. It does
+// nothing if reporter is nil.
+func Report(reporter Reporter, event Event) {
+	if reporter == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	reporter.Report(event)
+}
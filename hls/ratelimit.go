@@ -0,0 +1,196 @@
+package hls
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rateChunkSize int = 32 * 1024
+
+// RateLimiter is a token bucket shared by every segment reader. Tokens are
+// bytes, refilled at rate bytes per second with a burst of one second's worth.
+// A rate of 0 disables the limit.
+type RateLimiter struct {
+	lock   sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+
+	// changed is closed and replaced by SetRate to wake the readers waiting
+	// for tokens at the old rate.
+	changed chan struct{}
+}
+
+// ParseRate parses a rate such as "500K", "5M" or "1.5G" into bytes per second.
+// Suffixes are binary multiples, and an empty string or "0" means unlimited.
+func ParseRate(value string) (int64, error) {
+	rate := strings.ToUpper(strings.TrimSpace(value))
+	rate = strings.TrimSuffix(strings.TrimSuffix(rate, "/S"), "B")
+	if rate == "" {
+		return 0, nil
+	}
+
+	multiplier := 1.0
+	switch rate[len(rate)-1] {
+	case 'K':
+		multiplier = 1 << 10
+	case 'M':
+		multiplier = 1 << 20
+	case 'G':
+		multiplier = 1 << 30
+	}
+
+	if multiplier != 1 {
+		rate = rate[:len(rate)-1]
+	}
+
+	parsed, err := strconv.ParseFloat(rate, 64)
+	if err != nil || parsed < 0 || math.IsNaN(parsed) || math.IsInf(parsed, 0) || parsed*multiplier > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid rate %q", value)
+	}
+	return int64(parsed * multiplier), nil
+}
+
+// FormatRate formats a rate in bytes per second for display.
+func FormatRate(rate int64) string {
+	if rate <= 0 {
+		return "unlimited"
+	}
+	return FormatBytes(rate) + "/s"
+}
+
+// FormatBytes formats n bytes with a binary unit, ex. "1.5 MiB".
+func FormatBytes(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(n)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+
+	if i == 0 {
+		return fmt.Sprintf("%d %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[i])
+}
+
+// NewRateLimiter creates a limiter for rate bytes per second, 0 is unlimited.
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{
+		rate:    rate,
+		tokens:  float64(rate),
+		last:    time.Now(),
+		changed: make(chan struct{}),
+	}
+}
+
+// Rate returns the current limit in bytes per second.
+func (r *RateLimiter) Rate() int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.rate
+}
+
+// SetRate changes the limit while downloads are running.
+func (r *RateLimiter) SetRate(rate int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if rate < 0 {
+		rate = 0
+	}
+	r.rate = rate
+	r.tokens = 0
+	r.last = time.Now()
+
+	if r.changed != nil {
+		close(r.changed)
+	}
+	r.changed = make(chan struct{})
+}
+
+// wait blocks until n bytes can be read or ctx is done. Tokens are reserved
+// before waiting so concurrent readers queue up behind each other instead of
+// all waking at once. SetRate drops the reservations, so the readers it wakes
+// reserve again at the new rate.
+func (r *RateLimiter) wait(ctx context.Context, n int) error {
+	for {
+		r.lock.Lock()
+		if r.rate <= 0 {
+			r.lock.Unlock()
+			return nil
+		}
+
+		now := time.Now()
+		r.tokens += now.Sub(r.last).Seconds() * float64(r.rate)
+		r.last = now
+		if r.tokens > float64(r.rate) {
+			r.tokens = float64(r.rate)
+		}
+
+		r.tokens -= float64(n)
+		if r.tokens >= 0 {
+			r.lock.Unlock()
+			return nil
+		}
+
+		delay := time.Duration(-r.tokens / float64(r.rate) * float64(time.Second))
+		changed := r.changed
+		r.lock.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+			return nil
+		case <-changed:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+
+			// The bytes won't be read, so the other readers don't wait for them
+			r.lock.Lock()
+			if r.changed == changed {
+				r.tokens += float64(n)
+			}
+			r.lock.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+// Reader wraps reader so that everything read from it counts against the limit.
+// Reads waiting for the limit fail with ctx's error once it is done. A nil
+// RateLimiter returns reader as is.
+func (r *RateLimiter) Reader(ctx context.Context, reader io.Reader) io.Reader {
+	if r == nil {
+		return reader
+	}
+	return &limitedReader{ctx: ctx, limiter: r, reader: reader}
+}
+
+type limitedReader struct {
+	ctx     context.Context
+	limiter *RateLimiter
+	reader  io.Reader
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > rateChunkSize {
+		p = p[:rateChunkSize]
+	}
+
+	n, err := l.reader.Read(p)
+	if n > 0 {
+		if waitErr := l.limiter.wait(l.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
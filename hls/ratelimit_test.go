@@ -0,0 +1,156 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		err   bool
+	}{
+		{value: "", want: 0},
+		{value: "0", want: 0},
+		{value: "1000", want: 1000},
+		{value: "500K", want: 500 << 10},
+		{value: "500k", want: 500 << 10},
+		{value: "5M", want: 5 << 20},
+		{value: "5MB", want: 5 << 20},
+		{value: "5MB/s", want: 5 << 20},
+		{value: "1.5G", want: 3 << 29},
+		{value: " 2M ", want: 2 << 20},
+		{value: "-1", err: true},
+		{value: "-5M", err: true},
+		{value: "fast", err: true},
+		{value: "M", err: true},
+		{value: "NaN", err: true},
+		{value: "inf", err: true},
+		{value: "+Inf", err: true},
+		{value: "1e30G", err: true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseRate(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("ParseRate(%q) = %d, want an error", test.value, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseRate(%q) returned error: %v", test.value, err)
+		} else if got != test.want {
+			t.Errorf("ParseRate(%q) = %d, want %d", test.value, got, test.want)
+		}
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     int64
+		reads    []int
+		min, max time.Duration
+	}{
+		{name: "unlimited", rate: 0, reads: []int{1 << 20, 1 << 20}, min: 0, max: 50 * time.Millisecond},
+		{name: "within the burst", rate: 1000, reads: []int{500, 500}, min: 0, max: 50 * time.Millisecond},
+		{name: "past the burst", rate: 1000, reads: []int{1000, 250}, min: 200 * time.Millisecond, max: 600 * time.Millisecond},
+		{name: "several reads past the burst", rate: 2000, reads: []int{2000, 200, 200, 200}, min: 250 * time.Millisecond, max: 800 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		r := NewRateLimiter(test.rate)
+		start := time.Now()
+		for _, n := range test.reads {
+			if err := r.wait(context.Background(), n); err != nil {
+				t.Fatalf("%s: wait(%d) returned error: %v", test.name, n, err)
+			}
+		}
+
+		if elapsed := time.Since(start); elapsed < test.min || elapsed > test.max {
+			t.Errorf("%s: took %s, want between %s and %s", test.name, elapsed, test.min, test.max)
+		}
+	}
+}
+
+func TestRateLimiterSetRateWakes(t *testing.T) {
+	tests := []struct {
+		name string
+		rate int64
+	}{
+		{name: "unlimited", rate: 0},
+		{name: "faster", rate: 10 << 20},
+	}
+
+	for _, test := range tests {
+		r := NewRateLimiter(1000)
+		r.wait(context.Background(), 1000)
+
+		// At the old rate this read would wait 100 seconds
+		done := make(chan error, 1)
+		go func() {
+			done <- r.wait(context.Background(), 100000)
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		r.SetRate(test.rate)
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("%s: wait returned error: %v", test.name, err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: SetRate didn't wake the waiting reader", test.name)
+		}
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	r := NewRateLimiter(1000)
+	r.wait(context.Background(), 1000)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.wait(ctx, 100000); err != context.DeadlineExceeded {
+		t.Fatalf("cancelled wait returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The cancelled reader's bytes are given back, so the next one only waits
+	// for its own
+	start := time.Now()
+	if err := r.wait(context.Background(), 100); err != nil {
+		t.Fatalf("wait returned error: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("wait after a cancelled one took %s, want about 100ms", elapsed)
+	}
+}
+
+func TestRateLimiterReader(t *testing.T) {
+	r := NewRateLimiter(1000)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	data, err := ioutil.ReadAll(r.Reader(ctx, bytes.NewReader(make([]byte, 10000))))
+	if err != context.DeadlineExceeded {
+		t.Errorf("reading past the limit returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if len(data) == 0 || len(data) >= 10000 {
+		t.Errorf("read %d bytes before the deadline, want part of them", len(data))
+	}
+
+	var limiter *RateLimiter
+	data, err = ioutil.ReadAll(limiter.Reader(context.Background(), bytes.NewReader(make([]byte, 10000))))
+	if err != nil || len(data) != 10000 {
+		t.Errorf("nil limiter read %d bytes with error %v, want all of them", len(data), err)
+	}
+}
@@ -0,0 +1,191 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoFFmpeg is returned when the ffmpeg binary can't be found in the PATH or
+// the working directory.
+var ErrNoFFmpeg error = errors.New("ffmpeg not found, install it or place it in the working directory")
+
+func findAbsoluteBinary(name string) string {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		path = name
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		path = name
+	}
+	return path
+}
+
+// CheckFFmpeg returns ErrNoFFmpeg if RemuxMP4 won't be able to run ffmpeg, so
+// that it can be found out before anything is downloaded.
+func CheckFFmpeg() error {
+	if _, err := os.Stat(findAbsoluteBinary("ffmpeg")); err != nil {
+		if _, err := os.Stat(findAbsoluteBinary("ffmpeg.exe")); err != nil {
+			return ErrNoFFmpeg
+		}
+	}
+	return nil
+}
+
+// Metadata is written to the container by RemuxMP4.
+type Metadata struct {
+	// Tags are container tags by their ffmpeg name, ex. "title", "show",
+	// "season_number" or "episode_sort".
+	Tags map[string]string
+
+	// Language is the ISO 639-2 code of the audio stream, ex. "jpn".
+	Language string
+
+	// Chapters are written to the container if there are any.
+	Chapters []Chapter
+
+	// Start and Length cut the output to a part of src, if either is set.
+	// Cuts can't be made precisely without decoding, so RemuxMP4 re-encodes
+	// the streams instead of copying them.
+	Start  time.Duration
+	Length time.Duration
+}
+
+// trimmed reports whether the output is cut to a part of the input.
+func (m Metadata) trimmed() bool {
+	return m.Start > 0 || m.Length > 0
+}
+
+// args returns the ffmpeg arguments that set the metadata, with the tags in a
+// fixed order.
+func (m Metadata) args() []string {
+	keys := make([]string, 0, len(m.Tags))
+	for key, value := range m.Tags {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	args := []string{}
+	for _, key := range keys {
+		args = append(args, "-metadata", key+"="+m.Tags[key])
+	}
+
+	if m.Language != "" {
+		args = append(args, "-metadata:s:a:0", "language="+m.Language)
+	}
+	return args
+}
+
+func formatSeconds(duration time.Duration) string {
+	return strconv.FormatFloat(duration.Seconds(), 'f', 3, 64)
+}
+
+// inputArgs returns the ffmpeg arguments that read src, and the chapters of
+// metadata from a second input. The returned function removes the chapter file,
+// which is written next to dst while ffmpeg runs.
+func inputArgs(src, dst string, metadata Metadata) ([]string, func(), error) {
+	args := []string{"-i", src}
+	if len(metadata.Chapters) == 0 {
+		return args, func() {}, nil
+	}
+
+	chapterFile := dst + ".chapters"
+	if err := writeFFMetadata(chapterFile, metadata.Chapters); err != nil {
+		return nil, nil, err
+	}
+
+	args = append(args, "-f", "ffmetadata", "-i", chapterFile, "-map_chapters", "1")
+	return args, func() { os.Remove(chapterFile) }, nil
+}
+
+// trimArgs returns the ffmpeg arguments that cut the output to the part of
+// metadata, if it has one.
+func trimArgs(metadata Metadata) []string {
+	if metadata.trimmed() == false {
+		return nil
+	}
+
+	args := []string{"-ss", formatSeconds(metadata.Start)}
+	if metadata.Length > 0 {
+		args = append(args, "-t", formatSeconds(metadata.Length))
+	}
+	return args
+}
+
+// runFFmpeg runs ffmpeg with args, removing dst if it fails.
+func runFFmpeg(ctx context.Context, args []string, dst string) error {
+	cmd := exec.CommandContext(ctx, findAbsoluteBinary("ffmpeg"), args...)
+	if byteResult, err := cmd.Output(); err != nil {
+		os.Remove(dst)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			return ErrNoFFmpeg
+		}
+		return fmt.Errorf("running ts to %s: %w - result output: %s", strings.TrimPrefix(filepath.Ext(dst), "."), err, string(byteResult))
+	}
+	return nil
+}
+
+// RemuxMP4 copies the streams of the transport stream src into the MP4 dst
+// with ffmpeg, without re-encoding them, and tags it with metadata. When
+// metadata trims a section, the copied streams can't be cut precisely, so the
+// section is re-encoded with H.264 and AAC instead. dst is removed if ffmpeg
+// fails.
+func RemuxMP4(ctx context.Context, src, dst string, metadata Metadata) error {
+	args, cleanup, err := inputArgs(src, dst, metadata)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	args = append(args, "-map", "0")
+	if metadata.trimmed() {
+		args = append(args, "-c:v", "libx264", "-crf", "18", "-preset", "fast", "-c:a", "aac", "-b:a", "192k")
+	} else {
+		args = append(args, "-c:v", "copy", "-c:a", "copy")
+	}
+
+	args = append(args, trimArgs(metadata)...)
+	args = append(args, "-metadata", `encoding_tool="no_variable_data"`)
+	args = append(args, metadata.args()...)
+	args = append(args, "-y", dst)
+	return runFFmpeg(ctx, args, dst)
+}
+
+// RemuxAudio writes the first audio stream of the transport stream src to
+// dst, tagged with metadata. An Opus dst (ending in .opus) is encoded with
+// libopus, and anything else, such as M4A, gets a copy of the AAC audio. A cut
+// of the copied audio is precise to an audio frame. dst is removed if ffmpeg
+// fails.
+func RemuxAudio(ctx context.Context, src, dst string, metadata Metadata) error {
+	args, cleanup, err := inputArgs(src, dst, metadata)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	args = append(args, "-map", "0:a:0", "-vn")
+	if strings.EqualFold(filepath.Ext(dst), ".opus") {
+		args = append(args, "-c:a", "libopus", "-b:a", "128k")
+	} else {
+		args = append(args, "-c:a", "copy")
+	}
+
+	args = append(args, trimArgs(metadata)...)
+	args = append(args, metadata.args()...)
+	args = append(args, "-y", dst)
+	return runFFmpeg(ctx, args, dst)
+}
@@ -0,0 +1,183 @@
+package hls
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	initialChannels int           = 8
+	channelStep     int           = 2
+	tuneInterval    time.Duration = 2 * time.Second
+)
+
+// Scheduler caps the number of segment requests that are in flight at once
+// and the bandwidth they use. A single scheduler can be shared by several
+// downloaders, so running several episodes in parallel doesn't multiply the
+// number of open connections or go over the rate limit.
+//
+// Unless the number of connections is fixed, the scheduler tunes it while
+// downloading: it keeps adding connections while throughput improves and halves
+// them when requests time out or the server answers with 429 or 5xx.
+type Scheduler struct {
+	lock    sync.Mutex
+	cond    *sync.Cond
+	active  int
+	limit   int
+	max     int
+	fixed   bool
+	limiter *RateLimiter
+
+	// Reporter receives an event each time the number of connections changes.
+	Reporter Reporter
+
+	bytes     int64
+	throttled int
+	lastRate  float64
+	done      chan struct{}
+}
+
+// NewScheduler creates a scheduler with a fixed number of connections,
+// or an adaptive one that never goes over defaultChannels if connections is 0.
+func NewScheduler(connections int, limiter *RateLimiter) *Scheduler {
+	s := &Scheduler{
+		limit:   initialChannels,
+		max:     defaultChannels,
+		limiter: limiter,
+	}
+
+	if connections > 0 {
+		s.limit = connections
+		s.max = connections
+		s.fixed = true
+	}
+	s.cond = sync.NewCond(&s.lock)
+	return s
+}
+
+// Start begins tuning the number of connections in the background. A
+// scheduler that was already started keeps its tuning.
+func (s *Scheduler) Start() {
+	if s.fixed {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.done != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	s.done = done
+	go func() {
+		ticker := time.NewTicker(tuneInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.tune()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the tuning started by Start.
+func (s *Scheduler) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.done != nil {
+		close(s.done)
+		s.done = nil
+	}
+}
+
+// Limit returns the number of connections currently allowed.
+func (s *Scheduler) Limit() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.limit
+}
+
+func (s *Scheduler) acquire() {
+	s.lock.Lock()
+	for s.active >= s.limit {
+		s.cond.Wait()
+	}
+	s.active++
+	s.lock.Unlock()
+}
+
+func (s *Scheduler) release() {
+	s.lock.Lock()
+	s.active--
+	s.lock.Unlock()
+	s.cond.Signal()
+}
+
+// record counts n downloaded bytes towards the measured throughput.
+func (s *Scheduler) record(n int) {
+	s.lock.Lock()
+	s.bytes += int64(n)
+	s.lock.Unlock()
+}
+
+// fail notes a failed segment request, backing off later if it was caused by
+// the server or network being overloaded.
+func (s *Scheduler) fail(err error) {
+	if isThrottled(err) == false {
+		return
+	}
+
+	s.lock.Lock()
+	s.throttled++
+	s.lock.Unlock()
+}
+
+func (s *Scheduler) tune() {
+	s.lock.Lock()
+	rate := float64(s.bytes) / tuneInterval.Seconds()
+	previous := s.limit
+
+	if s.throttled > 0 {
+		s.limit /= 2
+		if s.limit < 1 {
+			s.limit = 1
+		}
+	} else if s.bytes > 0 && rate > s.lastRate*1.05 && s.active >= s.limit {
+		s.limit += channelStep
+		if s.limit > s.max {
+			s.limit = s.max
+		}
+	}
+
+	s.bytes = 0
+	s.throttled = 0
+	s.lastRate = rate
+	limit := s.limit
+	s.lock.Unlock()
+
+	if limit > previous {
+		s.cond.Broadcast()
+	}
+
+	if limit != previous {
+		Report(s.Reporter, Event{Type: ConnectionsChanged, Connections: limit, Bytes: int64(rate)})
+	}
+}
+
+// isThrottled reports whether err is a timeout, 429 or 5xx response.
+func isThrottled(err error) bool {
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.Code == 429 || status.Code >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,179 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedulerStartStop(t *testing.T) {
+	before := runtime.NumGoroutine()
+	s := NewScheduler(0, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				s.Start()
+				s.Limit()
+				s.Stop()
+			}
+		}()
+	}
+	wg.Wait()
+	s.Stop()
+
+	// The tuning goroutines exit once their done channel is closed
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines left running after Stop", n-before)
+	}
+}
+
+func TestSchedulerTune(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int
+		max       int
+		active    int
+		bytes     int64
+		lastRate  float64
+		throttled int
+		want      int
+	}{
+		{name: "ramps up on better throughput", limit: 8, max: 25, active: 8, bytes: 4 << 20, lastRate: 1 << 20, want: 10},
+		{name: "ramps up to max", limit: 24, max: 25, active: 24, bytes: 4 << 20, lastRate: 1 << 20, want: 25},
+		{name: "stays at max", limit: 25, max: 25, active: 25, bytes: 4 << 20, lastRate: 1 << 20, want: 25},
+		{name: "keeps idle connections", limit: 8, max: 25, active: 5, bytes: 4 << 20, lastRate: 1 << 20, want: 8},
+		{name: "keeps on flat throughput", limit: 8, max: 25, active: 8, bytes: 4 << 20, lastRate: 2 << 20, want: 8},
+		{name: "keeps without data", limit: 8, max: 25, active: 8, bytes: 0, lastRate: 0, want: 8},
+		{name: "halves on failure", limit: 8, max: 25, active: 8, bytes: 4 << 20, lastRate: 1 << 20, throttled: 1, want: 4},
+		{name: "halves odd limits down", limit: 5, max: 25, active: 5, throttled: 3, want: 2},
+		{name: "never below one", limit: 1, max: 25, active: 1, throttled: 1, want: 1},
+	}
+
+	for _, test := range tests {
+		var events []Event
+		s := NewScheduler(0, nil)
+		s.Reporter = ReporterFunc(func(event Event) {
+			events = append(events, event)
+		})
+		s.limit, s.max, s.active = test.limit, test.max, test.active
+		s.bytes, s.lastRate, s.throttled = test.bytes, test.lastRate, test.throttled
+
+		s.tune()
+		if got := s.Limit(); got != test.want {
+			t.Errorf("%s: limit = %d, want %d", test.name, got, test.want)
+		}
+
+		if s.bytes != 0 || s.throttled != 0 {
+			t.Errorf("%s: bytes = %d and throttled = %d after tuning, want 0", test.name, s.bytes, s.throttled)
+		}
+
+		changed := test.want != test.limit
+		if changed != (len(events) == 1) || (changed && events[0].Connections != test.want) {
+			t.Errorf("%s: events = %+v, want one with %d connections only if the limit changed", test.name, events, test.want)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Error = timeoutError{}
+
+func TestSchedulerFail(t *testing.T) {
+	tests := []struct {
+		err       error
+		throttled bool
+	}{
+		{err: &StatusError{Code: 429}, throttled: true},
+		{err: &StatusError{Code: 500}, throttled: true},
+		{err: fmt.Errorf("getting segment response: %w", &StatusError{Code: 503}), throttled: true},
+		{err: timeoutError{}, throttled: true},
+		{err: &StatusError{Code: 404}, throttled: false},
+		{err: &StatusError{Code: 403}, throttled: false},
+		{err: errors.New("connection reset"), throttled: false},
+	}
+
+	for _, test := range tests {
+		s := NewScheduler(0, nil)
+		s.fail(test.err)
+		if got := s.throttled > 0; got != test.throttled {
+			t.Errorf("fail(%v): throttled = %t, want %t", test.err, got, test.throttled)
+		}
+	}
+}
+
+func TestSchedulerAcquire(t *testing.T) {
+	tests := []struct {
+		name        string
+		connections int
+	}{
+		{name: "fixed", connections: 2},
+		{name: "single", connections: 1},
+	}
+
+	for _, test := range tests {
+		s := NewScheduler(test.connections, nil)
+		for i := 0; i < test.connections; i++ {
+			s.acquire()
+		}
+
+		acquired := make(chan struct{})
+		go func() {
+			s.acquire()
+			close(acquired)
+		}()
+
+		select {
+		case <-acquired:
+			t.Errorf("%s: acquired more than %d connections", test.name, test.connections)
+			continue
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		s.release()
+		select {
+		case <-acquired:
+		case <-time.After(time.Second):
+			t.Errorf("%s: release didn't free a connection", test.name)
+		}
+	}
+}
+
+func TestSchedulerTuneWakes(t *testing.T) {
+	s := NewScheduler(0, nil)
+	s.limit, s.active = 1, 1
+
+	acquired := make(chan struct{})
+	go func() {
+		s.acquire()
+		close(acquired)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	s.lock.Lock()
+	s.bytes, s.lastRate = 4<<20, 1<<20
+	s.lock.Unlock()
+	s.tune()
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Errorf("raising the limit didn't wake a waiting request")
+	}
+}
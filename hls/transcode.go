@@ -0,0 +1,140 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profile describes how Transcode re-encodes a file.
+type Profile struct {
+	VideoCodec   string // ffmpeg encoder, ex. "libx264" or "libx265", or "copy"
+	CRF          int    // Constant quality, used instead of VideoBitrate when set
+	VideoBitrate string // ex. "1500k"
+	Preset       string // Encoder preset, ex. "medium"
+	MaxHeight    int    // Taller videos are scaled down to it, 0 keeps the size
+	AudioCodec   string // ffmpeg encoder, ex. "aac" or "libopus", or "copy"
+	AudioBitrate string // ex. "128k"
+	Loudnorm     bool   // Normalizes the loudness with ffmpeg's loudnorm filter
+}
+
+// Profiles are the profiles that are built in, by name.
+var Profiles = map[string]Profile{
+	"mobile": {VideoCodec: "libx264", CRF: 26, Preset: "medium", MaxHeight: 720, AudioCodec: "aac", AudioBitrate: "128k", Loudnorm: true},
+	"small":  {VideoCodec: "libx265", CRF: 28, Preset: "medium", MaxHeight: 480, AudioCodec: "aac", AudioBitrate: "96k", Loudnorm: true},
+	"hevc":   {VideoCodec: "libx265", CRF: 22, Preset: "medium", AudioCodec: "copy"},
+}
+
+// Validate returns an error if ffmpeg can't be given the profile's settings.
+func (p Profile) Validate() error {
+	switch {
+	case p.VideoCodec == "":
+		return errors.New("no video codec")
+	case p.AudioCodec == "":
+		return errors.New("no audio codec")
+	case p.CRF < 0 || p.MaxHeight < 0:
+		return errors.New("crf and max height can't be negative")
+	case p.VideoCodec == "copy" && (p.CRF > 0 || p.VideoBitrate != "" || p.MaxHeight > 0):
+		return errors.New("a copied video can't have a crf, bitrate or max height")
+	case p.AudioCodec == "copy" && (p.AudioBitrate != "" || p.Loudnorm):
+		return errors.New("copied audio can't have a bitrate or be normalized")
+	}
+	return nil
+}
+
+func (p Profile) args() []string {
+	args := []string{"-c:v", p.VideoCodec}
+	if p.CRF > 0 {
+		args = append(args, "-crf", strconv.Itoa(p.CRF))
+	} else if p.VideoBitrate != "" {
+		args = append(args, "-b:v", p.VideoBitrate)
+	}
+
+	if p.Preset != "" && p.VideoCodec != "copy" {
+		args = append(args, "-preset", p.Preset)
+	}
+
+	// Apple's players only play HEVC in MP4 when it is tagged as hvc1
+	if strings.Contains(p.VideoCodec, "265") || strings.Contains(p.VideoCodec, "hevc") {
+		args = append(args, "-tag:v", "hvc1")
+	}
+
+	if p.MaxHeight > 0 {
+		args = append(args, "-vf", fmt.Sprintf("scale=-2:'min(ih,%d)'", p.MaxHeight))
+	}
+
+	args = append(args, "-c:a", p.AudioCodec)
+	if p.AudioBitrate != "" {
+		args = append(args, "-b:a", p.AudioBitrate)
+	}
+
+	// loudnorm upsamples to 192kHz, so the result is brought back down
+	if p.Loudnorm {
+		args = append(args, "-af", "loudnorm=I=-16:TP=-1.5:LRA=11,aresample=48000")
+	}
+	return args
+}
+
+// Transcode re-encodes src with profile, keeping its tags and chapters, and
+// replaces src with the result. progress is called with how much of src has
+// been encoded so far, if it isn't nil. The result is written to a separate
+// file first, so src is left as it was if ffmpeg fails.
+func Transcode(ctx context.Context, src string, profile Profile, progress func(done time.Duration)) error {
+	ext := filepath.Ext(src)
+	dst := strings.TrimSuffix(src, ext) + ".transcode" + ext
+
+	args := []string{"-nostats", "-loglevel", "error", "-progress", "pipe:1", "-i", src, "-map", "0", "-map_metadata", "0", "-map_chapters", "0"}
+	args = append(args, profile.args()...)
+	args = append(args, "-y", dst)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, findAbsoluteBinary("ffmpeg"), args...)
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("creating ffmpeg pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			return ErrNoFFmpeg
+		}
+		return fmt.Errorf("starting ffmpeg: %w", err)
+	}
+
+	// ffmpeg writes "key=value" lines, and out_time_us is how far it got
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if progress == nil || strings.HasPrefix(line, "out_time_us=") == false {
+			continue
+		}
+
+		if us, err := strconv.ParseInt(strings.TrimPrefix(line, "out_time_us="), 10, 64); err == nil && us >= 0 {
+			progress(time.Duration(us) * time.Microsecond)
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		os.Remove(dst)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("running transcode: %w - result output: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	if err := os.Rename(dst, src); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("replacing with transcoded file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// hookFields returns the values -exec-after can use for an episode, by their
+// name. They are the fields of -output, formatted the same way but without
+// cleaning them for file names, along with where the episode was saved.
+func hookFields(job *episodeJob) map[string]string {
+	episode := job.episode
+	path, err := filepath.Abs(job.filepath + job.filename)
+	if err != nil {
+		path = job.filepath + job.filename
+	}
+
+	fields := map[string]string{
+		"path":         path,
+		"dir":          filepath.Dir(path),
+		"filename":     job.filename,
+		"url":          episode.EpisodeURL,
+		"series":       episode.SeriesTitle,
+		"season":       fmt.Sprintf("%02s", episode.SeasonNumber),
+		"season_name":  getSeason(episode.SeasonNumber),
+		"season_title": episode.SeasonTitle,
+		"episode":      fmt.Sprintf("%02s", episode.Number),
+		"title":        episode.Title,
+		"media_id":     episode.MediaID,
+		"series_id":    episode.SeriesID,
+		"air_date":     "",
+	}
+
+	if episode.AirDate.IsZero() == false {
+		fields["air_date"] = episode.AirDate.Format("2006-01-02")
+	}
+	return fields
+}
+
+// hookCommand returns command with its {field}s replaced by the quoted values
+// of the episode, and the environment it runs with, which has each field as
+// CRUNCHYRIP_FIELD as well. Since the values are quoted already, a {field}
+// inside quotes of the command keeps the quote characters.
+func hookCommand(command string, job *episodeJob) (string, []string) {
+	fields := hookFields(job)
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	env := os.Environ()
+	replacements := make([]string, 0, len(fields)*2)
+	for _, name := range names {
+		env = append(env, "CRUNCHYRIP_"+strings.ToUpper(name)+"="+fields[name])
+		replacements = append(replacements, "{"+name+"}", shellQuote(fields[name]))
+	}
+	return strings.NewReplacer(replacements...).Replace(command), env
+}
+
+// runHook runs the -exec-after command for an episode that was saved. Its
+// output goes to the terminal, or to stderr with -json so it doesn't mix with
+// the records.
+func (p *pipeline) runHook(job *episodeJob) error {
+	command, env := hookCommand(p.execAfter, job)
+	cmd := shellCommand(p.ctx, command)
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if jsonOutput {
+		cmd.Stdout = os.Stderr
+	}
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running -exec-after: %w", err)
+	}
+	return nil
+}
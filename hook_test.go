@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/turtletowerz/crunchyrip/crunchyroll"
+)
+
+func hookJob() *episodeJob {
+	return &episodeJob{
+		episode: &crunchyroll.Episode{
+			EpisodeURL:   "https://www.crunchyroll.com/my-hero-academia/episode-1-izuku-midoriya-origin-730015",
+			SeriesTitle:  "My Hero Academia",
+			SeasonNumber: "1",
+			SeasonTitle:  "My Hero Academia",
+			Number:       "3",
+			Title:        `Roaring Muscles "Part 1" & it's $HOME`,
+			MediaID:      "730019",
+			SeriesID:     "271127",
+			AirDate:      time.Date(2016, 4, 17, 0, 0, 0, 0, time.UTC),
+		},
+		filepath: filepath.Join("My Hero Academia", "Season One") + string(filepath.Separator),
+		filename: "My Hero Academia - S01E03 - Roaring Muscles.mp4",
+	}
+}
+
+func TestHookCommand(t *testing.T) {
+	job := hookJob()
+	path, _ := filepath.Abs(job.filepath + job.filename)
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{command: "echo done", want: "echo done"},
+		{command: "mv {path} /media", want: "mv " + shellQuote(path) + " /media"},
+		{command: "notify S{season}E{episode}", want: "notify S" + shellQuote("01") + "E" + shellQuote("03")},
+		{command: "echo {title}", want: "echo " + shellQuote(`Roaring Muscles "Part 1" & it's $HOME`)},
+		{command: "echo {air_date} {season_name} {unknown}", want: "echo " + shellQuote("2016-04-17") + " " + shellQuote("Season One") + " {unknown}"},
+		{command: "cp {filename} {dir}", want: "cp " + shellQuote(job.filename) + " " + shellQuote(filepath.Dir(path))},
+	}
+
+	for _, test := range tests {
+		got, _ := hookCommand(test.command, job)
+		if got != test.want {
+			t.Errorf("hookCommand(%q) = %q, want %q", test.command, got, test.want)
+		}
+	}
+}
+
+func TestHookEnvironment(t *testing.T) {
+	_, env := hookCommand("true", hookJob())
+
+	want := []string{
+		"CRUNCHYRIP_SEASON=01",
+		"CRUNCHYRIP_EPISODE=03",
+		"CRUNCHYRIP_SERIES=My Hero Academia",
+		`CRUNCHYRIP_TITLE=Roaring Muscles "Part 1" & it's $HOME`,
+		"CRUNCHYRIP_MEDIA_ID=730019",
+	}
+
+	joined := "\n" + strings.Join(env, "\n") + "\n"
+	for _, variable := range want {
+		if strings.Contains(joined, "\n"+variable+"\n") == false {
+			t.Errorf("environment is missing %q", variable)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []string{
+		"plain",
+		"with spaces",
+		"it's",
+		`"double" quotes`,
+		"$HOME and `id` and $(id)",
+		`back\slash`,
+		"semi; colon && pipe | glob *",
+		"",
+	}
+
+	for _, value := range tests {
+		out, err := shellCommand(context.Background(), "printf %s "+shellQuote(value)).Output()
+		if err != nil {
+			t.Errorf("shellQuote(%q): running sh: %v", value, err)
+		} else if string(out) != value {
+			t.Errorf("shellQuote(%q) was read by sh as %q", value, out)
+		}
+	}
+}
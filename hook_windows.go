@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// shellCommand runs command with cmd. The command line is passed as it is,
+// since cmd doesn't read arguments the way Go quotes them.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "cmd")
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: `cmd /S /C "` + command + `"`}
+	return cmd
+}
+
+// shellQuote quotes value so that cmd reads it as a single argument.
+func shellQuote(value string) string {
+	return `"` + strings.ReplaceAll(value, `"`, `""`) + `"`
+}
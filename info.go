@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/turtletowerz/crunchyrip/crunchyroll"
+	"github.com/turtletowerz/m3u8"
+)
+
+// variantInfo is one quality of a stream's master playlist.
+type variantInfo struct {
+	Resolution string   `json:"resolution,omitempty"`
+	Bandwidth  uint32   `json:"bandwidth"`
+	Codecs     string   `json:"codecs,omitempty"`
+	FrameRate  float64  `json:"frame_rate,omitempty"`
+	Audio      []string `json:"audio,omitempty"`
+}
+
+// streamInfo is a stream of an episode along with the qualities it comes in.
+// Only HLS streams have their variants listed.
+type streamInfo struct {
+	crunchyroll.Stream
+	Variants []variantInfo `json:"variants,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// infoRecord is written by `info` for an episode.
+type infoRecord struct {
+	Type      string                 `json:"type"`
+	Episode   episodeRecord          `json:"episode"`
+	Streams   []streamInfo           `json:"streams"`
+	Subtitles []crunchyroll.Subtitle `json:"subtitles"`
+	Qualities []string               `json:"qualities"`
+}
+
+// countedInfo is an entry of `info -season`, along with the number of episodes
+// it was found in.
+type countedInfo struct {
+	Name     string `json:"name"`
+	Episodes int    `json:"episodes"`
+
+	// Only set for qualities
+	Bandwidth uint32 `json:"bandwidth,omitempty"`
+	Codecs    string `json:"codecs,omitempty"`
+}
+
+// seasonInfoRecord is written by `info -season`.
+type seasonInfoRecord struct {
+	Type      string        `json:"type"`
+	URL       string        `json:"url"`
+	Episodes  int           `json:"episodes"`
+	Streams   []countedInfo `json:"streams"`
+	Subtitles []countedInfo `json:"subtitles"`
+	Qualities []countedInfo `json:"qualities"`
+}
+
+func hardsubName(lang string) string {
+	if lang == "" {
+		return "none"
+	}
+	return lang
+}
+
+func resolutionName(variant *m3u8.Variant) string {
+	if variant.Resolution == nil {
+		return "audio only"
+	}
+	return fmt.Sprintf("%dx%d", variant.Resolution.Width, variant.Resolution.Height)
+}
+
+func formatBandwidth(bandwidth uint32) string {
+	if bandwidth >= 1000000 {
+		return fmt.Sprintf("%.1f Mbps", float64(bandwidth)/1000000)
+	}
+	return fmt.Sprintf("%d kbps", bandwidth/1000)
+}
+
+func newVariantInfo(variant *m3u8.Variant) variantInfo {
+	info := variantInfo{
+		Bandwidth: variant.Bandwidth,
+		Codecs:    variant.Codecs,
+		FrameRate: variant.FrameRate,
+	}
+
+	if variant.Resolution != nil {
+		info.Resolution = resolutionName(variant)
+	}
+
+	for _, alt := range variant.Alternatives {
+		if alt != nil && alt.Type == "AUDIO" && alt.Language != "" {
+			info.Audio = append(info.Audio, alt.Language)
+		}
+	}
+	return info
+}
+
+// fetchEpisodes returns the episodes of showURL, or the episode itself.
+func fetchEpisodes(ctx context.Context, session *crunchyroll.Session, showURL string, dubbed bool) ([]*crunchyroll.Episode, error) {
+	if _, err := crunchyroll.IsSeries(showURL); err != nil {
+		return nil, withCode(exitNotFound, err)
+	}
+
+	episodes, err := session.Episodes(ctx, showURL, dubbed)
+	if err != nil {
+		return nil, fmt.Errorf("getting episodes: %w", err)
+	}
+
+	if len(episodes) == 0 {
+		return nil, withCode(exitNotFound, fmt.Errorf("No episodes found!"))
+	}
+	return episodes, nil
+}
+
+// streamInventory fetches the variants of every HLS stream of an episode. A
+// stream whose playlist can't be read keeps the error instead.
+func streamInventory(ctx context.Context, session *crunchyroll.Session, episode *crunchyroll.Episode) []streamInfo {
+	streams := make([]streamInfo, len(episode.Streams))
+	for i, stream := range episode.Streams {
+		streams[i].Stream = stream
+		if strings.Contains(stream.Format, "hls") == false || stream.URL == "" {
+			continue
+		}
+
+		variants, err := crunchyroll.Variants(ctx, session, stream.URL)
+		if err != nil {
+			streams[i].Error = err.Error()
+			continue
+		}
+
+		for _, variant := range variants {
+			streams[i].Variants = append(streams[i].Variants, newVariantInfo(variant))
+		}
+	}
+	return streams
+}
+
+// printInfo prints every stream of an episode with its qualities, and its
+// subtitles. If showURL links to a series, the first episode is used.
+func printInfo(ctx context.Context, session *crunchyroll.Session, showURL string, f *downloadFlags) error {
+	subLang := f.subs
+	if f.dub {
+		subLang = "none"
+	}
+
+	episodes, err := fetchEpisodes(ctx, session, showURL, f.dub)
+	if err != nil {
+		return err
+	}
+
+	episode := episodes[0]
+	if err := episode.FetchInfo(ctx, session, subLang); err != nil && errors.Is(err, crunchyroll.ErrNoStream) == false {
+		return fmt.Errorf("getting episode info: %w", err)
+	}
+
+	logCyan("%s - Episode %s - %s", episode.SeriesTitle, episode.Number, episode.Title)
+	logInfo("Streams:")
+
+	streams := streamInventory(ctx, session, episode)
+	for _, stream := range streams {
+		writeOutput("  %-28s audio: %-6s hardsubs: %s", stream.Format, stream.AudioLang, hardsubName(stream.HardsubLang))
+		if stream.Error != "" {
+			writeOutput("      error: %s", stream.Error)
+		}
+
+		for _, variant := range stream.Variants {
+			line := fmt.Sprintf("      %-10s %9s  %s", variant.Resolution, formatBandwidth(variant.Bandwidth), variant.Codecs)
+			if len(variant.Audio) > 0 {
+				line += "  audio: " + strings.Join(variant.Audio, ", ")
+			}
+			writeOutput(line)
+		}
+	}
+
+	logInfo("Subtitles:")
+	for _, subtitle := range episode.Subtitles {
+		writeOutput("  %-6s %s", subtitle.Language, subtitle.Format)
+	}
+
+	var qualities []string
+	if episode.StreamURL == "" {
+		logInfo("No stream with subtitle language %q", subLang)
+	} else {
+		_, qualities, err = crunchyroll.BestVariant(ctx, session, episode.StreamURL, f.quality)
+		if len(qualities) == 0 && err != nil {
+			return fmt.Errorf("getting qualities: %w", err)
+		}
+		logInfo("Available qualities: %s", strings.Join(qualities, ", "))
+	}
+
+	emit(infoRecord{
+		Type:      "info",
+		Episode:   newEpisodeRecord(episode),
+		Streams:   streams,
+		Subtitles: episode.Subtitles,
+		Qualities: qualities,
+	})
+	return nil
+}
+
+// counter counts entries by name, keeping the order they were first seen in.
+type counter struct {
+	entries []*countedInfo
+	index   map[string]*countedInfo
+}
+
+func (c *counter) add(name string) *countedInfo {
+	if c.index == nil {
+		c.index = map[string]*countedInfo{}
+	}
+
+	entry, exists := c.index[name]
+	if exists == false {
+		entry = &countedInfo{Name: name}
+		c.index[name] = entry
+		c.entries = append(c.entries, entry)
+	}
+	entry.Episodes++
+	return entry
+}
+
+func (c *counter) list() []countedInfo {
+	list := make([]countedInfo, len(c.entries))
+	for i, entry := range c.entries {
+		list[i] = *entry
+	}
+	return list
+}
+
+// printSeasonInfo prints which streams, subtitles and qualities the episodes of
+// a season come in, and how many of the episodes have each of them. Only the
+// qualities of the stream that would be downloaded are checked.
+func printSeasonInfo(ctx context.Context, session *crunchyroll.Session, showURL string, f *downloadFlags) error {
+	subLang := f.subs
+	if f.dub {
+		subLang = "none"
+	}
+
+	episodes, err := fetchEpisodes(ctx, session, showURL, f.dub)
+	if err != nil {
+		return err
+	}
+
+	var streams, subtitles, qualities counter
+	var seriesTitle string
+
+	for i, episode := range episodes {
+		logInfo("Checking episode %d of %d...", i+1, len(episodes))
+		if err := episode.FetchInfo(ctx, session, subLang); err != nil && errors.Is(err, crunchyroll.ErrNoStream) == false {
+			return fmt.Errorf("getting episode info: %w", err)
+		}
+		seriesTitle = episode.SeriesTitle
+
+		for _, stream := range episode.Streams {
+			streams.add(fmt.Sprintf("%s audio: %s hardsubs: %s", stream.Format, stream.AudioLang, hardsubName(stream.HardsubLang)))
+		}
+
+		for _, subtitle := range episode.Subtitles {
+			subtitles.add(subtitle.Language)
+		}
+
+		if episode.StreamURL == "" {
+			continue
+		}
+
+		variants, err := crunchyroll.Variants(ctx, session, episode.StreamURL)
+		if err != nil {
+			return fmt.Errorf("getting qualities of %q: %w", episode.EpisodeURL, err)
+		}
+
+		// Count each resolution once per episode, with its highest bandwidth
+		seen := map[string]bool{}
+		for _, variant := range variants {
+			name := resolutionName(variant)
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+
+			entry := qualities.add(name)
+			if variant.Bandwidth > entry.Bandwidth {
+				entry.Bandwidth = variant.Bandwidth
+				entry.Codecs = variant.Codecs
+			}
+		}
+	}
+
+	total := len(episodes)
+	logCyan("%s - %d episode(s)", seriesTitle, total)
+	logInfo("Streams:")
+	for _, entry := range streams.entries {
+		writeOutput("  %-60s %d/%d episodes", entry.Name, entry.Episodes, total)
+	}
+
+	logInfo("Subtitles:")
+	for _, entry := range subtitles.entries {
+		writeOutput("  %-6s %d/%d episodes", entry.Name, entry.Episodes, total)
+	}
+
+	logInfo("Qualities of the stream with hardsubs %q:", hardsubName(strings.ReplaceAll(subLang, "-", "")))
+	for _, entry := range qualities.entries {
+		writeOutput("  %-10s up to %9s  %-24s %d/%d episodes", entry.Name, formatBandwidth(entry.Bandwidth), entry.Codecs, entry.Episodes, total)
+	}
+
+	emit(seasonInfoRecord{
+		Type:      "season_info",
+		URL:       showURL,
+		Episodes:  total,
+		Streams:   streams.list(),
+		Subtitles: subtitles.list(),
+		Qualities: qualities.list(),
+	})
+	return nil
+}
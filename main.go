@@ -1,57 +1,75 @@
 package main
 
 import (
-	"flag"
+	"context"
 	"fmt"
 	"math/rand"
 	"os"
+	"os/signal"
 	"regexp"
 	"strconv"
 	"time"
 
 	"github.com/gookit/color"
+	"github.com/turtletowerz/crunchyrip/crunchyroll"
+	"github.com/turtletowerz/crunchyrip/hls"
 )
 
 const (
-	crunchyrollReg string = `https://www.crunchyroll.com/([a-z0-9-]+)/?([a-z0-9-]+)?`
-	prefix         string = "[crunchyrip] "
-	illegalChars   string = `[\\\\/:*?\"<>|]`
-	pathSep        string = string(os.PathSeparator)
+	version      string = "v0.0.2"
+	prefix       string = "[crunchyrip] "
+	illegalChars string = `[\\\\/:*?\"<>|]`
+	pathSep      string = string(os.PathSeparator)
 )
 
 var (
-	errOptions error  = fmt.Errorf("OPTIONS_ERROR")
+	errSkipped error  = fmt.Errorf("SKIPPED_ERROR")
 	tempDir    string = os.TempDir() + string(os.PathSeparator) + "crunchyrip"
-	tsStorage  string = tempDir + pathSep + "tsStorage"
 
 	// I need to figure out the anime with the most seasons
 	numbers = []string{"One", "Two", "Three", "Four", "Five", "Six", "Seven", "Eight", "Nine", "Ten"}
-
-	resolutionList = map[string]string{
-		"240":  "428x240",
-		"360":  "640x360",
-		"480":  "848x480",
-		"720":  "1280x720",
-		"1080": "1920x1080",
-	}
 )
 
 func logCyan(format string, a ...interface{}) {
+	if jsonOutput {
+		fmt.Fprintf(os.Stderr, prefix+format+"\n", a...)
+		return
+	}
 	color.Cyan.Printf(prefix+format+"\n", a...)
 }
 
 func logInfo(format string, a ...interface{}) {
+	if jsonOutput {
+		fmt.Fprintf(os.Stderr, prefix+format+"\n", a...)
+		return
+	}
 	color.White.Printf(prefix+format+"\n", a...)
 }
 
 func logSuccess(format string, a ...interface{}) {
+	if jsonOutput {
+		fmt.Fprintf(os.Stderr, prefix+format+"\n", a...)
+		return
+	}
 	color.Green.Printf(prefix+format+"\n", a...)
 }
 
 func logError(err error) {
+	if jsonOutput {
+		emitError(err)
+		return
+	}
 	color.Red.Println(prefix + "Error " + err.Error())
 }
 
+func writeOutput(format string, a ...interface{}) {
+	if jsonOutput {
+		fmt.Fprintf(os.Stderr, format+"\n", a...)
+		return
+	}
+	fmt.Printf(format+"\n", a...)
+}
+
 func renameFile(src, dst string) error {
 	for i := 0; i < 10; i++ {
 		if err := os.Rename(src, dst); err == nil {
@@ -70,102 +88,144 @@ func getSeason(season string) string {
 	if num == 0 {
 		return "Specials"
 	}
+
+	if num < 0 || num > len(numbers) {
+		return "Season " + season
+	}
 	return "Season " + numbers[num-1]
 }
 
-func main() {
-	rand.Seed(time.Now().UnixNano())
-	options := flag.Bool("options", false, "If true, will print all available resolutions and subtitle languages for the series")
-	dub := flag.Bool("dub", false, "If true, will attempt to download English version")
-	subs := flag.String("subs", "en-US", "Subtitle language: en-US, ja-JP (default en-US)")
-	flag.StringVar(subs, "s", *subs, "Subtitle language: en-US, ja-JP (default en-US) (shorthand)")
-	quality := flag.String("quality", "720", "Stream quality (default 720)")
-	flag.StringVar(quality, "q", *quality, "Stream quality (shorthand)")
-
-	flag.Parse()
-	if flag.NArg() < 3 {
-		logInfo("Usage: crunchyrip [flags] username password series-url")
-		os.Exit(1)
-	}
-
-	logCyan("crunchyrip v0.0.2 - by turtletowerz")
-	logCyan("Attempting to login to crunchyroll account")
-	logInfo("Logging into Crunchyroll...")
-	crunchyrollClient := newHTTPClient()
-
-	if err := crunchyrollClient.Login(flag.Arg(0), flag.Arg(1)); err != nil {
-		logError(err)
-		return
-	}
+// interruptContext returns a context that is cancelled on the first interrupt.
+// A second interrupt exits straight away.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt)
+
+	go func() {
+		<-signals
+		logInfo("Interrupted, stopping downloads... (press Ctrl+C again to quit immediately)")
+		cancel()
+		<-signals
+		os.Exit(exitInterrupted)
+	}()
+	return ctx
+}
 
-	logSuccess("Crunchyroll login successful!")
-	if err := download(crunchyrollClient, flag.Arg(2), *quality, *subs, *dub, *options); err != nil {
-		logError(err)
-	}
-	return
+func main() {
+	os.Exit(run())
 }
 
-func download(client *httpClient, showURL, quality, subLang string, dubbed, options bool) error {
-	_, statErr := os.Stat(tempDir)
-	if statErr != nil {
-		logInfo("Generating new temporary directory")
-		os.Mkdir(tempDir, os.ModePerm)
-	}
+// run dispatches to the command named by the first argument, returning the
+// exit code. Arguments that don't start with a command name are downloaded as
+// before: crunchyrip [flags] username password series-url
+func run() int {
+	rand.Seed(time.Now().UnixNano())
 
-	if dubbed {
-		subLang = "none"
+	args := os.Args[1:]
+	if len(args) == 0 {
+		usage()
+		return exitUsage
 	}
 
-	logInfo("Scraping show metadata...")
-	episodes, err := getEpisodes(client, showURL, dubbed)
-	if err != nil {
-		return fmt.Errorf("getting episodes: %w", err)
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		usage()
+		return exitOK
 	}
 
-	if len(episodes) == 0 {
-		return fmt.Errorf("No episodes found!")
+	if cmd := findCommand(args[0]); cmd != nil {
+		return cmd.run(cmd, args[1:])
 	}
 
-	singleEpisode := (len(episodes) == 1)
+	cmd := findCommand("download")
+	return cmd.run(cmd, args)
+}
 
-	for _, episode := range episodes {
-		logInfo("Retrieving Episode Info...")
-		if err := episode.GetEpisodeInfo(client, subLang); err != nil {
-			logError(fmt.Errorf("getting episode info: %w", err))
-			continue
+func download(ctx context.Context, session *crunchyroll.Session, showURL string, opts downloadOptions) error {
+	if opts.dryRun == false {
+		if err := hls.CheckFFmpeg(); err != nil {
+			return err
 		}
 
-		filename := cleanFilename(fmt.Sprintf("%s - S%02sE%02s - %s.mp4", episode.SeriesTitle, episode.SeasonNumber, episode.Number, episode.Title))
-
-		var filepath string
-		if singleEpisode == false {
-			filepath = cleanFilename(episode.SeriesTitle) + pathSep + getSeason(episode.SeasonNumber) + pathSep
-			os.MkdirAll(filepath, os.ModePerm)
+		_, statErr := os.Stat(tempDir)
+		if statErr != nil {
+			logInfo("Generating new temporary directory")
+			os.MkdirAll(tempDir, os.ModePerm)
 		}
+	}
 
-		if _, err := os.Stat(filepath + filename); err == nil {
-			logSuccess("%s.mkv has already been downloaded successfully!", episode.Title)
-			continue
-		}
+	if opts.dubbed {
+		opts.subLang = "none"
+	}
 
-		logCyan("Downloading: %s", episode.Title)
-		if err := episode.Download(client, quality, options); err != nil {
-			if err == errOptions {
-				return nil
-			}
-			logError(fmt.Errorf("downloading episode: %w", err))
-			continue
+	jobs, singleEpisode, err := selectEpisodes(ctx, session, showURL, opts)
+	if err != nil {
+		opts.summary.failURL(showURL, err, opts)
+		if ctx.Err() != nil {
+			return withCode(exitInterrupted, err)
 		}
+		return withCode(exitTotal, err)
+	}
+
+	urls := make([]string, len(jobs))
+	for i, job := range jobs {
+		urls[i] = job.episode.EpisodeURL
+	}
+	emit(struct {
+		Type     string   `json:"type"`
+		URL      string   `json:"url"`
+		Episodes []string `json:"episodes"`
+	}{"episodes", showURL, urls})
+
+	pipe := newPipeline(session, opts, showURL, singleEpisode)
+
+	// Keep the temporary directory so the next run can resume the segments
+	if pipe.Run(ctx, jobs) == false {
+		return withCode(exitInterrupted, fmt.Errorf("interrupted, partial downloads were kept in %q", tempDir))
+	}
 
-		if err := renameFile(tempDir+pathSep+"episode.mp4", filepath+filename); err != nil {
-			logError(fmt.Errorf("renaming file: %w", err))
-			continue
+	if pipe.failed > 0 {
+		code := exitPartial
+		if int(pipe.failed) == len(jobs) {
+			code = exitTotal
 		}
-		logSuccess("Downloading completed successfully!")
+		return withCode(code, fmt.Errorf("%d of %d episode(s) failed to download", pipe.failed, len(jobs)))
+	}
 
+	if opts.dryRun {
+		logCyan("Dry run complete, nothing was downloaded")
+		return nil
 	}
+
 	logCyan("Completed downloading episode(s)!")
 	logInfo("Cleaning up temporary directory...")
 	os.RemoveAll(tempDir)
 	return nil
 }
+
+// selectEpisodes returns a job for each episode of showURL in the -episodes
+// range, and whether showURL links to a single episode.
+func selectEpisodes(ctx context.Context, session *crunchyroll.Session, showURL string, opts downloadOptions) ([]*episodeJob, bool, error) {
+	logInfo("Scraping show metadata...")
+	episodes, err := fetchEpisodes(ctx, session, showURL, opts.dubbed)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The range only applies to the episodes of a series
+	series, _ := crunchyroll.IsSeries(showURL)
+	jobs := []*episodeJob{}
+	for i, episode := range episodes {
+		if series == false || opts.episodes.contains(i+1) {
+			jobs = append(jobs, &episodeJob{episode: episode, position: i + 1})
+		}
+	}
+
+	if len(jobs) == 0 {
+		return nil, false, withCode(exitNotFound, fmt.Errorf("none of the %d episode(s) are in the -episodes range", len(episodes)))
+	}
+
+	// Whether the episodes go in the series folders depends on the url, not
+	// on how many of its episodes were picked
+	return jobs, len(episodes) == 1, nil
+}
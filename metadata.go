@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/turtletowerz/crunchyrip/crunchyroll"
+	"github.com/turtletowerz/crunchyrip/hls"
+)
+
+// languageCodes maps the languages Crunchyroll uses to ISO 639-2 codes.
+var languageCodes = map[string]string{
+	"jaJP": "jpn",
+	"enUS": "eng",
+	"enGB": "eng",
+	"esLA": "spa",
+	"esES": "spa",
+	"ptBR": "por",
+	"ptPT": "por",
+	"frFR": "fra",
+	"deDE": "deu",
+	"itIT": "ita",
+	"ruRU": "rus",
+	"arME": "ara",
+	"arSA": "ara",
+	"zhCN": "zho",
+	"zhTW": "zho",
+	"koKR": "kor",
+}
+
+// languageCode returns the ISO 639-2 code of a Crunchyroll language such as
+// "jaJP" or "en-US", or an empty string if it isn't known.
+func languageCode(lang string) string {
+	return languageCodes[strings.ReplaceAll(lang, "-", "")]
+}
+
+// episodeMetadata returns the tags written to the output file of an episode,
+// using the MP4 names ffmpeg maps to iTunes atoms so that players and media
+// servers pick them up. The Crunchyroll media ID goes in episode_id.
+func episodeMetadata(episode *crunchyroll.Episode) hls.Metadata {
+	tags := map[string]string{
+		"title":       episode.Title,
+		"show":        episode.SeriesTitle,
+		"episode_id":  episode.MediaID,
+		"description": episode.Description,
+		"synopsis":    episode.Description,
+		"network":     "Crunchyroll",
+		"media_type":  "10", // TV Show
+	}
+
+	// These are stored as integers, so specials such as "SP" are left out
+	if _, err := strconv.Atoi(episode.SeasonNumber); err == nil {
+		tags["season_number"] = episode.SeasonNumber
+	}
+
+	if _, err := strconv.Atoi(episode.Number); err == nil {
+		tags["episode_sort"] = episode.Number
+	}
+
+	if episode.SeasonTitle != "" {
+		tags["album"] = episode.SeasonTitle
+	}
+
+	if episode.AirDate.IsZero() == false {
+		tags["date"] = episode.AirDate.Format("2006-01-02")
+	}
+
+	return hls.Metadata{
+		Tags:     tags,
+		Language: languageCode(episode.AudioLang),
+	}
+}
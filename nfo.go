@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/turtletowerz/crunchyrip/crunchyroll"
+	"github.com/turtletowerz/crunchyrip/hls"
+)
+
+// The NFO files follow the format Kodi documents, which Jellyfin and Emby read
+// as well.
+
+type nfoUniqueID struct {
+	Type    string `xml:"type,attr"`
+	Default bool   `xml:"default,attr"`
+	Value   string `xml:",chardata"`
+}
+
+type nfoThumb struct {
+	Aspect string `xml:"aspect,attr,omitempty"`
+	URL    string `xml:",chardata"`
+}
+
+type tvshowNFO struct {
+	XMLName  xml.Name    `xml:"tvshow"`
+	Title    string      `xml:"title"`
+	Plot     string      `xml:"plot,omitempty"`
+	Studio   string      `xml:"studio"`
+	Thumbs   []nfoThumb  `xml:"thumb"`
+	UniqueID nfoUniqueID `xml:"uniqueid"`
+}
+
+type seasonNFO struct {
+	XMLName      xml.Name `xml:"season"`
+	Title        string   `xml:"title"`
+	SeasonNumber int      `xml:"seasonnumber"`
+}
+
+type episodeNFO struct {
+	XMLName   xml.Name     `xml:"episodedetails"`
+	Title     string       `xml:"title"`
+	ShowTitle string       `xml:"showtitle"`
+	Season    string       `xml:"season"`
+	Episode   string       `xml:"episode"`
+	Plot      string       `xml:"plot,omitempty"`
+	Aired     string       `xml:"aired,omitempty"`
+	Studio    string       `xml:"studio"`
+	Thumb     string       `xml:"thumb,omitempty"`
+	UniqueID  *nfoUniqueID `xml:"uniqueid,omitempty"`
+}
+
+func newEpisodeNFO(episode *crunchyroll.Episode) episodeNFO {
+	nfo := episodeNFO{
+		Title:     episode.Title,
+		ShowTitle: episode.SeriesTitle,
+		Season:    episode.SeasonNumber,
+		Episode:   episode.Number,
+		Plot:      episode.Description,
+		Studio:    "Crunchyroll",
+		Thumb:     episode.ThumbnailURL,
+	}
+
+	if episode.AirDate.IsZero() == false {
+		nfo.Aired = episode.AirDate.Format("2006-01-02")
+	}
+
+	if episode.MediaID != "" {
+		nfo.UniqueID = &nfoUniqueID{Type: "crunchyroll", Default: true, Value: episode.MediaID}
+	}
+	return nfo
+}
+
+func newTVShowNFO(series *crunchyroll.Series) tvshowNFO {
+	nfo := tvshowNFO{
+		Title:    series.Title,
+		Plot:     series.Description,
+		Studio:   "Crunchyroll",
+		UniqueID: nfoUniqueID{Type: "crunchyroll", Default: true, Value: path.Base(series.URL)},
+	}
+
+	if series.PosterURL != "" {
+		nfo.Thumbs = append(nfo.Thumbs, nfoThumb{"poster", series.PosterURL})
+	}
+
+	if series.BannerURL != "" {
+		nfo.Thumbs = append(nfo.Thumbs, nfoThumb{"banner", series.BannerURL})
+	}
+	return nfo
+}
+
+func writeXML(filename string, value interface{}) error {
+	data, err := xml.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", filepath.Base(filename), err)
+	}
+
+	data = append([]byte(xml.Header), append(data, '\n')...)
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", filepath.Base(filename), err)
+	}
+	return nil
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// imageExt returns the extension of the image at imageURL, or .jpg if it
+// doesn't have one.
+func imageExt(imageURL string) string {
+	if parsed, err := url.Parse(imageURL); err == nil {
+		if ext := path.Ext(parsed.Path); ext != "" {
+			return ext
+		}
+	}
+	return ".jpg"
+}
+
+// downloadFile saves the response of fileURL to filename. It is written to a
+// .part file first, so filename never holds a partial download.
+func downloadFile(ctx context.Context, session *crunchyroll.Session, fileURL, filename string) error {
+	resp, err := session.Get(ctx, fileURL)
+	if err != nil {
+		return fmt.Errorf("getting %s: %w", filepath.Base(filename), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("getting %s: %w", filepath.Base(filename), &hls.StatusError{Code: resp.StatusCode, URL: fileURL})
+	}
+
+	file, err := os.Create(filename + ".part")
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", filepath.Base(filename), err)
+	}
+
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		os.Remove(filename + ".part")
+		return fmt.Errorf("writing %s: %w", filepath.Base(filename), err)
+	}
+
+	file.Close()
+	return renameFile(filename+".part", filename)
+}
+
+// sidecarDirs returns the series and season directories of an episode, ending
+// in a separator, or empty strings if the output layout doesn't have them. A
+// season directory is only recognized when the template's last directory
+// uses one of the season fields.
+func (p *pipeline) sidecarDirs(job *episodeJob) (string, string) {
+	parent := func(dir string) string {
+		return filepath.Dir(strings.TrimSuffix(dir, pathSep)) + pathSep
+	}
+
+	if p.output == "" {
+		if p.singleEpisode {
+			return "", ""
+		}
+		return parent(job.filepath), job.filepath
+	}
+
+	templateDir := filepath.Dir(filepath.FromSlash(p.output))
+	switch {
+	case job.filepath == "":
+		return "", ""
+	case strings.Contains(filepath.Base(templateDir), "{season"):
+		return parent(job.filepath), job.filepath
+	case strings.Contains(templateDir, "{series}"):
+		return job.filepath, ""
+	}
+	return "", ""
+}
+
+// sidecars writes the NFO files and downloads the artwork of an episode, for
+// media servers such as Kodi and Jellyfin. The files of the series and season
+// are only written if they don't exist yet. Failures are reported as warnings
+// since the episode itself was saved.
+func (p *pipeline) sidecars(job *episodeJob) {
+	if p.nfo == false && p.artwork == false {
+		return
+	}
+
+	warn := func(err error) {
+		if err != nil {
+			p.report(job, hls.Event{Type: hls.Warning, Error: err.Error()})
+		}
+	}
+
+	episode := job.episode
+	base := job.filepath + strings.TrimSuffix(job.filename, filepath.Ext(job.filename))
+	if p.nfo {
+		warn(writeXML(base+".nfo", newEpisodeNFO(episode)))
+	}
+
+	if p.artwork && episode.ThumbnailURL != "" {
+		warn(downloadFile(p.ctx, p.session, episode.ThumbnailURL, base+"-thumb"+imageExt(episode.ThumbnailURL)))
+	}
+
+	seriesDir, seasonDir := p.sidecarDirs(job)
+	if p.nfo && seasonDir != "" && fileExists(seasonDir+"season.nfo") == false {
+		number, _ := strconv.Atoi(episode.SeasonNumber)
+		warn(writeXML(seasonDir+"season.nfo", seasonNFO{Title: getSeason(episode.SeasonNumber), SeasonNumber: number}))
+	}
+
+	// The series page is only read once for each directory
+	if seriesDir == "" || p.seriesDone[seriesDir] {
+		return
+	}
+	p.seriesDone[seriesDir] = true
+
+	seriesURL, err := crunchyroll.SeriesURL(episode.EpisodeURL)
+	if err != nil {
+		warn(err)
+		return
+	}
+
+	series, err := p.session.SeriesInfo(p.ctx, seriesURL)
+	if err != nil {
+		warn(err)
+		return
+	}
+
+	if series.Title == "" {
+		series.Title = episode.SeriesTitle
+	}
+
+	if p.nfo && fileExists(seriesDir+"tvshow.nfo") == false {
+		warn(writeXML(seriesDir+"tvshow.nfo", newTVShowNFO(series)))
+	}
+
+	if p.artwork {
+		for name, imageURL := range map[string]string{"poster": series.PosterURL, "banner": series.BannerURL} {
+			filename := seriesDir + name + imageExt(imageURL)
+			if imageURL != "" && fileExists(filename) == false {
+				warn(downloadFile(p.ctx, p.session, imageURL, filename))
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/turtletowerz/crunchyrip/crunchyroll"
+)
+
+var (
+	// jsonOutput is set by the -json flag. Records are then written to stdout
+	// as JSON lines, and the regular log messages go to stderr instead.
+	jsonOutput bool
+	jsonWriter io.Writer = &lockedWriter{writer: os.Stdout}
+)
+
+// lockedWriter serializes writes so that records written from different
+// goroutines never end up on the same line.
+type lockedWriter struct {
+	lock   sync.Mutex
+	writer io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.writer.Write(p)
+}
+
+// episodeRecord describes an episode once its details are known.
+type episodeRecord struct {
+	Type         string `json:"type"`
+	URL          string `json:"url"`
+	Title        string `json:"title"`
+	Number       string `json:"number"`
+	SeriesTitle  string `json:"series_title"`
+	SeasonNumber string `json:"season_number"`
+	Path         string `json:"path"`
+	Exists       bool   `json:"exists"`
+
+	MediaID       string  `json:"media_id,omitempty"`
+	SeriesID      string  `json:"series_id,omitempty"`
+	SeasonTitle   string  `json:"season_title,omitempty"`
+	Description   string  `json:"description,omitempty"`
+	AirDate       string  `json:"air_date,omitempty"`
+	Duration      float64 `json:"duration,omitempty"` // In seconds
+	ThumbnailURL  string  `json:"thumbnail_url,omitempty"`
+	ContentRating string  `json:"content_rating,omitempty"`
+}
+
+func newEpisodeRecord(episode *crunchyroll.Episode) episodeRecord {
+	record := episodeRecord{
+		Type:          "episode",
+		URL:           episode.EpisodeURL,
+		Title:         episode.Title,
+		Number:        episode.Number,
+		SeriesTitle:   episode.SeriesTitle,
+		SeasonNumber:  episode.SeasonNumber,
+		MediaID:       episode.MediaID,
+		SeriesID:      episode.SeriesID,
+		SeasonTitle:   episode.SeasonTitle,
+		Description:   episode.Description,
+		Duration:      episode.Duration.Seconds(),
+		ThumbnailURL:  episode.ThumbnailURL,
+		ContentRating: episode.ContentRating,
+	}
+
+	if episode.AirDate.IsZero() == false {
+		record.AirDate = episode.AirDate.Format("2006-01-02")
+	}
+	return record
+}
+
+// infoFile is saved as the .info.json file of an episode by -info-json, with
+// everything known about it when it was downloaded.
+type infoFile struct {
+	Episode    episodeRecord          `json:"episode"`
+	Stream     *crunchyroll.Stream    `json:"stream,omitempty"`
+	Variant    *variantInfo           `json:"variant,omitempty"`
+	Subtitles  []crunchyroll.Subtitle `json:"subtitles"`
+	Downloaded time.Time              `json:"downloaded"`
+	Version    string                 `json:"version"`
+}
+
+// writeInfoJSON saves the info file of a downloaded episode beside it.
+func writeInfoJSON(job *episodeJob) error {
+	episode := job.episode
+	info := infoFile{
+		Episode:    newEpisodeRecord(episode),
+		Subtitles:  episode.Subtitles,
+		Downloaded: time.Now().UTC(),
+		Version:    version,
+	}
+	info.Episode.Path = job.filepath + job.filename
+	info.Episode.Exists = true
+
+	for i := range episode.Streams {
+		if episode.Streams[i].URL == episode.StreamURL {
+			info.Stream = &episode.Streams[i]
+			break
+		}
+	}
+
+	if job.variant != nil {
+		variant := newVariantInfo(job.variant)
+		info.Variant = &variant
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding info file: %w", err)
+	}
+
+	filename := job.filepath + strings.TrimSuffix(job.filename, filepath.Ext(job.filename)) + ".info.json"
+	if err := ioutil.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing info file: %w", err)
+	}
+	return nil
+}
+
+// streamRecord describes the stream chosen for an episode.
+type streamRecord struct {
+	Type       string   `json:"type"`
+	URL        string   `json:"url"`
+	Resolution string   `json:"resolution,omitempty"`
+	Bandwidth  uint32   `json:"bandwidth,omitempty"`
+	Codecs     string   `json:"codecs,omitempty"`
+	URI        string   `json:"uri,omitempty"`
+	Qualities  []string `json:"qualities"`
+}
+
+// emit writes a single record when -json is set.
+func emit(record interface{}) {
+	if jsonOutput {
+		json.NewEncoder(jsonWriter).Encode(record)
+	}
+}
+
+// emitError writes the error object that ends a failed run.
+func emitError(err error) {
+	emit(struct {
+		Type  string `json:"type"`
+		Error string `json:"error"`
+	}{"error", err.Error()})
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/turtletowerz/crunchyrip/crunchyroll"
+	"github.com/turtletowerz/crunchyrip/hls"
+	"github.com/turtletowerz/m3u8"
+)
+
+// episodeJob carries a single episode through each stage of the pipeline.
+type episodeJob struct {
+	episode    *crunchyroll.Episode
+	position   int
+	downloader *hls.Downloader
+	variant    *m3u8.Variant
+	filepath   string
+	filename   string
+
+	// The part of the stream that is kept by -section
+	start  time.Duration
+	length time.Duration
+}
+
+// tempName returns a name that is unique to the episode, used for its files in
+// the temporary directory so that several episodes can be downloaded at once.
+func tempName(episode *crunchyroll.Episode) string {
+	return cleanFilename(path.Base(strings.TrimSuffix(episode.EpisodeURL, "/")))
+}
+
+// downloadOptions holds the settings that apply to every episode of a run.
+type downloadOptions struct {
+	quality     string
+	subLang     string
+	dubbed      bool
+	parallel    int
+	connections int
+	output      string
+	episodes    episodeRanges
+	dryRun      bool
+	nfo         bool
+	artwork     bool
+	infoJSON    bool
+	noChapters  bool
+	stripAds    bool
+	section     *section
+	audioFormat string       // "m4a" or "opus" for -audio-only, empty for video
+	transcode   *hls.Profile // From -transcode, nil to keep the copied streams
+	execAfter   string
+	chapters    []hls.Chapter // From -chapters, instead of the ones found for each episode
+	limiter     *hls.RateLimiter
+	reporter    hls.Reporter
+	summary     *summary
+}
+
+// pipeline splits the handling of each episode into stages that are joined by
+// bounded queues: metadata -> download -> remux -> finalize. Every stage works
+// on a different episode at the same time, so ffmpeg can remux one episode
+// while the segments of the next ones are being fetched.
+type pipeline struct {
+	downloadOptions
+	session       *crunchyroll.Session
+	scheduler     *hls.Scheduler
+	showURL       string
+	singleEpisode bool
+
+	ctx    context.Context
+	failed int32
+
+	// Series directories whose NFO and artwork were handled, only used by
+	// the finalize stage
+	seriesDone map[string]bool
+}
+
+func newPipeline(session *crunchyroll.Session, opts downloadOptions, showURL string, singleEpisode bool) *pipeline {
+	if opts.parallel < 1 {
+		opts.parallel = 1
+	}
+
+	// The summary counts the bytes of every finished segment
+	if opts.summary != nil {
+		opts.reporter = hls.Reporters{opts.reporter, opts.summary}
+	}
+
+	scheduler := hls.NewScheduler(opts.connections, opts.limiter)
+	scheduler.Reporter = opts.reporter
+
+	return &pipeline{
+		downloadOptions: opts,
+		session:         session,
+		scheduler:       scheduler,
+		showURL:         showURL,
+		singleEpisode:   singleEpisode,
+		seriesDone:      map[string]bool{},
+	}
+}
+
+// Run sends every job through the pipeline and waits for all of them to
+// finish. It returns false if ctx was cancelled, in which case the episodes
+// that were in progress are dropped.
+func (p *pipeline) Run(ctx context.Context, episodes []*episodeJob) bool {
+	p.ctx = ctx
+	p.scheduler.Start()
+	defer p.scheduler.Stop()
+
+	jobs := make(chan *episodeJob)
+	go func() {
+		defer close(jobs)
+		for _, job := range episodes {
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	described := p.stage(1, p.parallel, jobs, p.metadata)
+	downloaded := p.stage(p.parallel, 1, described, p.download)
+	remuxed := p.stage(1, 1, downloaded, p.remux)
+
+	for job := range remuxed {
+		if err := p.finalize(job); err != nil {
+			p.fail(job, err)
+		}
+	}
+
+	logInfo("Segment connections: %d", p.scheduler.Limit())
+
+	return ctx.Err() == nil
+}
+
+// stage starts workers that apply fn to every job from in. Jobs that succeed
+// are passed on to the returned queue, which holds up to size jobs and is
+// closed once every worker has returned.
+func (p *pipeline) stage(workers, size int, in <-chan *episodeJob, fn func(*episodeJob) error) <-chan *episodeJob {
+	out := make(chan *episodeJob, size)
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for job := range in {
+				if p.ctx.Err() != nil {
+					continue
+				}
+
+				if err := fn(job); err != nil {
+					if p.ctx.Err() != nil {
+						continue
+					} else if err != errSkipped {
+						p.fail(job, err)
+					}
+					continue
+				}
+				out <- job
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+func (p *pipeline) metadata(job *episodeJob) error {
+	episode := job.episode
+
+	logInfo("Retrieving Episode Info...")
+	if err := episode.FetchInfo(p.ctx, p.session, p.subLang); err != nil {
+		return fmt.Errorf("getting episode info: %w", err)
+	}
+
+	job.filepath, job.filename = p.outputPath(episode)
+	if p.section != nil {
+		job.filename = strings.TrimSuffix(job.filename, p.extension()) + " (" + p.section.String() + ")" + p.extension()
+	}
+	if job.filepath != "" && p.dryRun == false {
+		os.MkdirAll(job.filepath, os.ModePerm)
+	}
+
+	_, statErr := os.Stat(job.filepath + job.filename)
+	record := newEpisodeRecord(episode)
+	record.Path = job.filepath + job.filename
+	record.Exists = statErr == nil
+	emit(record)
+
+	if statErr == nil {
+		logSuccess("%s has already been downloaded successfully!", job.filename)
+		p.summary.skip(job)
+		return errSkipped
+	}
+	return nil
+}
+
+// outputPath returns the directory, ending in a separator, and the filename an
+// episode is saved to. Without an -output template, a single episode is saved
+// to the working directory and a series to "Series/Season X/".
+func (p *pipeline) outputPath(episode *crunchyroll.Episode) (string, string) {
+	if p.output == "" {
+		filename := cleanFilename(fmt.Sprintf("%s - S%02sE%02s - %s", episode.SeriesTitle, episode.SeasonNumber, episode.Number, episode.Title)) + p.extension()
+		if p.singleEpisode {
+			return "", filename
+		}
+		return cleanFilename(episode.SeriesTitle) + pathSep + getSeason(episode.SeasonNumber) + pathSep, filename
+	}
+
+	var airDate string
+	if episode.AirDate.IsZero() == false {
+		airDate = episode.AirDate.Format("2006-01-02")
+	}
+
+	// The fields are cleaned so that only the separators of the template
+	// itself create directories
+	fields := strings.NewReplacer(
+		"{series}", cleanFilename(episode.SeriesTitle),
+		"{season}", fmt.Sprintf("%02s", episode.SeasonNumber),
+		"{season_name}", getSeason(episode.SeasonNumber),
+		"{episode}", fmt.Sprintf("%02s", cleanFilename(episode.Number)),
+		"{title}", cleanFilename(episode.Title),
+		"{season_title}", cleanFilename(episode.SeasonTitle),
+		"{air_date}", airDate,
+		"{media_id}", cleanFilename(episode.MediaID),
+		"{series_id}", cleanFilename(episode.SeriesID),
+	)
+	return filepath.Split(filepath.FromSlash(fields.Replace(p.output) + p.extension()))
+}
+
+// extension returns the extension of the files that are written.
+func (p *pipeline) extension() string {
+	if p.audioFormat != "" {
+		return "." + p.audioFormat
+	}
+	return ".mp4"
+}
+
+func (p *pipeline) download(job *episodeJob) error {
+	episode := job.episode
+
+	var best *m3u8.Variant
+	var qualities []string
+	var err error
+
+	if p.audioFormat != "" {
+		best, err = crunchyroll.AudioVariant(p.ctx, p.session, episode.StreamURL)
+	} else {
+		best, qualities, err = crunchyroll.BestVariant(p.ctx, p.session, episode.StreamURL, p.quality)
+	}
+
+	if len(qualities) > 0 {
+		logInfo("Available qualities: %s", strings.Join(qualities, ", "))
+	}
+
+	record := streamRecord{Type: "stream", URL: episode.EpisodeURL, Qualities: qualities}
+	if best != nil {
+		record.Resolution = resolutionName(best)
+		record.Bandwidth = best.Bandwidth
+		record.Codecs = best.Codecs
+		record.URI = best.URI
+	}
+	emit(record)
+
+	if err != nil {
+		return fmt.Errorf("getting best stream url: %w", err)
+	}
+
+	logInfo("Closest quality: %s", resolutionName(best))
+	if p.dryRun {
+		return p.plan(job, best)
+	}
+	p.report(job, hls.Event{Type: hls.EpisodeStarted})
+
+	downloader, err := hls.New(p.ctx, p.session, best.URI, hls.Options{
+		Name:      tempName(episode),
+		Dir:       tempDir,
+		Scheduler: p.scheduler,
+		Reporter:  p.reporter,
+	})
+	if err != nil {
+		return fmt.Errorf("creating hls downloader: %w", err)
+	}
+	p.findAds(job, downloader)
+
+	if err := p.cut(job, downloader); err != nil {
+		return err
+	}
+
+	if err = downloader.Download(p.ctx); err != nil {
+		return fmt.Errorf("downloading stream: %w", err)
+	}
+	job.downloader = downloader
+	job.variant = best
+	return nil
+}
+
+// findAds reports the ad breaks of the episode's playlist, leaving the certain
+// ones out of the download if -strip-ads was given.
+func (p *pipeline) findAds(job *episodeJob, downloader *hls.Downloader) {
+	var breaks []hls.AdBreak
+	short := 0
+	for _, adBreak := range downloader.AdBreaks() {
+		if adBreak.Certain() {
+			breaks = append(breaks, adBreak)
+		} else {
+			short++
+		}
+	}
+
+	if short > 0 {
+		p.report(job, hls.Event{Type: hls.Warning, Error: fmt.Sprintf("%d short part(s) may be ads, but only their length marks them so they are never stripped", short)})
+	}
+
+	if len(breaks) == 0 {
+		return
+	}
+
+	event := hls.Event{Type: hls.AdBreaksFound, Total: len(breaks)}
+	for _, adBreak := range breaks {
+		event.Duration += adBreak.Duration
+	}
+
+	if p.stripAds {
+		event.Completed = len(downloader.StripAds())
+	}
+	p.report(job, event)
+}
+
+// cut leaves the segments outside of -section out of the download, and keeps
+// where the section starts and how long it is so that remux can trim it.
+func (p *pipeline) cut(job *episodeJob, downloader *hls.Downloader) error {
+	if p.section == nil {
+		return nil
+	}
+
+	offset, err := downloader.Section(p.section.start, p.section.end)
+	if err != nil {
+		return fmt.Errorf("cutting %s: %w", p.section, err)
+	}
+
+	job.start = p.section.start - offset
+	if p.section.end > 0 {
+		job.length = p.section.end - p.section.start
+	}
+	return nil
+}
+
+// planRecord is written by -dry-run for each episode that would be downloaded.
+type planRecord struct {
+	Type           string  `json:"type"`
+	URL            string  `json:"url"`
+	Path           string  `json:"path"`
+	Resolution     string  `json:"resolution"`
+	Bandwidth      uint32  `json:"bandwidth"`
+	AudioLang      string  `json:"audio_lang"`
+	HardsubLang    string  `json:"hardsub_lang"`
+	Seconds        float64 `json:"seconds"`
+	EstimatedBytes int64   `json:"estimated_bytes"`
+}
+
+// plan prints what downloading the episode of job would do instead of doing
+// it. Only the media playlist is fetched, to estimate the size from its
+// duration and the variant's bandwidth.
+func (p *pipeline) plan(job *episodeJob, best *m3u8.Variant) error {
+	episode := job.episode
+	downloader, err := hls.New(p.ctx, p.session, best.URI, hls.Options{Name: tempName(episode), Dir: tempDir})
+	if err != nil {
+		return fmt.Errorf("getting media playlist: %w", err)
+	}
+	p.findAds(job, downloader)
+
+	if err := p.cut(job, downloader); err != nil {
+		return err
+	}
+
+	duration := downloader.Duration()
+	estimate := int64(float64(best.Bandwidth) / 8 * duration.Seconds())
+
+	record := planRecord{
+		Type:           "plan",
+		URL:            episode.EpisodeURL,
+		Path:           job.filepath + job.filename,
+		Resolution:     resolutionName(best),
+		Bandwidth:      best.Bandwidth,
+		Seconds:        duration.Seconds(),
+		EstimatedBytes: estimate,
+	}
+
+	for _, stream := range episode.Streams {
+		if stream.URL == episode.StreamURL {
+			record.AudioLang = stream.AudioLang
+			record.HardsubLang = stream.HardsubLang
+			break
+		}
+	}
+
+	logCyan("Would download %s", record.Path)
+	size := "size unknown"
+	if estimate > 0 {
+		size = "about " + hls.FormatBytes(estimate)
+	}
+	writeOutput("  %s, audio: %s, hardsubs: %s, %s, %s", record.Resolution, record.AudioLang, hardsubName(record.HardsubLang), duration.Round(time.Second), size)
+	emit(record)
+	p.summary.plan(job, estimate)
+	return errSkipped
+}
+
+func (p *pipeline) remux(job *episodeJob) error {
+	src := job.downloader.Output()
+	p.report(job, hls.Event{Type: hls.RemuxStarted, Path: src})
+
+	metadata := episodeMetadata(job.episode)
+	metadata.Start = job.start
+	metadata.Length = job.length
+
+	// The chapters of the whole episode don't line up with a section
+	if p.noChapters == false && p.section == nil {
+		duration := job.downloader.Duration()
+		metadata.Chapters = episodeChapters(job.episode, job.downloader.Discontinuities(), duration, p.chapters)
+	}
+
+	dst := tempDir + pathSep + tempName(job.episode) + p.extension()
+	if p.audioFormat != "" {
+		if err := hls.RemuxAudio(p.ctx, src, dst, metadata); err != nil {
+			return fmt.Errorf("converting to %s: %w", p.audioFormat, err)
+		}
+	} else if err := hls.RemuxMP4(p.ctx, src, dst, metadata); err != nil {
+		return fmt.Errorf("converting to mp4: %w", err)
+	}
+
+	if p.transcode != nil {
+		if err := p.transcodeFile(job, dst); err != nil {
+			return err
+		}
+	}
+	os.Remove(src)
+	return nil
+}
+
+// transcodeFile re-encodes the remuxed file of an episode with the -transcode
+// profile. If that fails the remuxed file is kept as it is, so only a warning
+// is reported unless the run was interrupted.
+func (p *pipeline) transcodeFile(job *episodeJob, file string) error {
+	total := job.downloader.Duration()
+	if job.length > 0 {
+		total = job.length
+	}
+
+	last := -1
+	progress := func(done time.Duration) {
+		if seconds := int(done.Seconds()); seconds != last {
+			last = seconds
+			p.report(job, hls.Event{Type: hls.TranscodeProgress, Completed: seconds, Total: int(total.Seconds())})
+		}
+	}
+
+	if err := hls.Transcode(p.ctx, file, *p.transcode, progress); err != nil {
+		if p.ctx.Err() != nil {
+			return err
+		}
+		p.report(job, hls.Event{Type: hls.Warning, Error: fmt.Sprintf("transcoding failed, keeping the original: %v", err)})
+	}
+	return nil
+}
+
+func (p *pipeline) finalize(job *episodeJob) error {
+	if err := renameFile(tempDir+pathSep+tempName(job.episode)+p.extension(), job.filepath+job.filename); err != nil {
+		return fmt.Errorf("renaming file: %w", err)
+	}
+	p.report(job, hls.Event{Type: hls.Finished, Path: job.filepath + job.filename})
+	p.sidecars(job)
+	if p.infoJSON {
+		if err := writeInfoJSON(job); err != nil {
+			p.report(job, hls.Event{Type: hls.Warning, Error: err.Error()})
+		}
+	}
+
+	// The episode was saved, so a failed hook is only noted in the summary
+	if p.execAfter != "" {
+		if err := p.runHook(job); err != nil {
+			p.report(job, hls.Event{Type: hls.Warning, Error: err.Error()})
+			p.summary.hookFail(job, err)
+		}
+	}
+	p.summary.done(job)
+	return nil
+}
+
+// report sends an event about the episode of job.
+func (p *pipeline) report(job *episodeJob, event hls.Event) {
+	event.Name = tempName(job.episode)
+	event.Title = job.episode.Title
+	hls.Report(p.reporter, event)
+}
+
+func (p *pipeline) fail(job *episodeJob, err error) {
+	atomic.AddInt32(&p.failed, 1)
+	p.report(job, hls.Event{Type: hls.Failed, Error: err.Error()})
+	p.summary.fail(p.showURL, p.singleEpisode, job, err, p.downloadOptions)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/schollz/progressbar"
+	"github.com/turtletowerz/crunchyrip/hls"
+)
+
+// terminalReporter prints download events as coloured log lines, with a
+// progress bar for the segments of each episode.
+type terminalReporter struct {
+	lock       sync.Mutex
+	bars       map[string]*progressbar.ProgressBar
+	transcoded map[string]int // Seconds shown on the transcode bar of each episode
+}
+
+func newTerminalReporter() *terminalReporter {
+	return &terminalReporter{
+		bars:       map[string]*progressbar.ProgressBar{},
+		transcoded: map[string]int{},
+	}
+}
+
+func (t *terminalReporter) bar(event hls.Event) *progressbar.ProgressBar {
+	bar, exists := t.bars[event.Name]
+	if exists == false {
+		bar = progressbar.New(event.Total)
+		t.bars[event.Name] = bar
+	}
+	return bar
+}
+
+// transcodeBar shows the progress of a transcode on a bar of its own, in
+// seconds of the episode.
+func (t *terminalReporter) transcodeBar(event hls.Event) {
+	name := event.Name + " transcode"
+	bar, exists := t.bars[name]
+	if exists == false {
+		writeOutput("\nTranscoding %q", event.Name)
+		bar = progressbar.New(event.Total)
+		t.bars[name] = bar
+	}
+
+	if done := event.Completed; done > t.transcoded[name] && done <= event.Total {
+		bar.Add(done - t.transcoded[name])
+		t.transcoded[name] = done
+	}
+}
+
+func (t *terminalReporter) Report(event hls.Event) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	switch event.Type {
+	case hls.EpisodeStarted:
+		logCyan("Downloading: %s", event.Title)
+	case hls.Resumed:
+		writeOutput("Resuming with %d of %d segments already downloaded", event.Completed, event.Total)
+		t.bar(event).Add(event.Completed)
+	case hls.SegmentDone:
+		t.bar(event).Add(1)
+	case hls.SegmentRetry:
+		writeOutput("failed to download %d (will retry): %s", *event.Segment, event.Error)
+	case hls.Warning:
+		if event.Segment == nil {
+			writeOutput("Warning: %s", event.Error)
+			break
+		}
+		writeOutput("Error with segment %d: %s", *event.Segment, event.Error)
+	case hls.RemuxStarted:
+		writeOutput("\nConverting %q", event.Name+".ts")
+	case hls.Finished:
+		delete(t.bars, event.Name)
+		delete(t.bars, event.Name+" transcode")
+		delete(t.transcoded, event.Name+" transcode")
+		logSuccess("Downloading completed successfully: %s", event.Path)
+	case hls.Failed:
+		delete(t.bars, event.Name)
+		delete(t.bars, event.Name+" transcode")
+		delete(t.transcoded, event.Name+" transcode")
+		logError(errors.New(event.Error))
+	case hls.AdBreaksFound:
+		if event.Completed > 0 {
+			logInfo("Stripped %d ad break(s), %s", event.Completed, event.Duration.Round(time.Second))
+		} else {
+			logInfo("Found %d ad break(s), %s (use -strip-ads to leave them out)", event.Total, event.Duration.Round(time.Second))
+		}
+	case hls.TranscodeProgress:
+		t.transcodeBar(event)
+	case hls.ConnectionsChanged:
+		logInfo("Using %d connections (%s)", event.Connections, hls.FormatRate(event.Bytes))
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/turtletowerz/crunchyrip/crunchyroll"
+	"github.com/turtletowerz/crunchyrip/hls"
+)
+
+const serveQueueSize int = 1000
+
+// serveJob is a download that was queued through the HTTP API.
+type serveJob struct {
+	ID       int       `json:"id"`
+	URL      string    `json:"url"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Queued   time.Time `json:"queued"`
+	Finished time.Time `json:"finished,omitempty"`
+}
+
+// server runs the downloads queued through its HTTP API one after another.
+type server struct {
+	lock    sync.Mutex
+	jobs    []*serveJob
+	queue   chan *serveJob
+	session *crunchyroll.Session
+	opts    downloadOptions
+}
+
+func addServeFlags(fs *flag.FlagSet) (*downloadFlags, *string) {
+	f := addDownloadFlags(fs)
+	addr := fs.String("addr", "127.0.0.1:8080", "Address for the HTTP API to listen on (default 127.0.0.1:8080)")
+	return f, addr
+}
+
+func runServe(cmd *command, args []string) int {
+	fs, g := newFlagSet(cmd)
+	f, addr := addServeFlags(fs)
+	if code, ok := parseFlags(fs, g, args); ok == false {
+		return code
+	}
+
+	ctx := interruptContext()
+	session, _, err := openSession(ctx, cmd, g, fs.Args(), 0)
+	if err != nil {
+		return exitWith(err)
+	}
+
+	opts, closer, err := f.downloadOptions()
+	defer closer()
+	if err != nil {
+		return exitWith(err)
+	}
+
+	if err := hls.CheckFFmpeg(); err != nil {
+		return exitWith(err)
+	}
+
+	srv := &server{
+		queue:   make(chan *serveJob, serveQueueSize),
+		session: session,
+		opts:    opts,
+	}
+	go srv.work(ctx)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/downloads", srv.handleDownloads)
+	mux.HandleFunc("/limit-rate", srv.handleLimitRate)
+	httpServer := &http.Server{Addr: *addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		httpServer.Shutdown(context.Background())
+	}()
+
+	logCyan("Listening on http://%s", *addr)
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return exitWith(err)
+	}
+	return exitInterrupted
+}
+
+func (s *server) work(ctx context.Context) {
+	for {
+		select {
+		case job := <-s.queue:
+			s.setStatus(job, "running", nil)
+			s.setStatus(job, "done", download(ctx, s.session, job.URL, s.opts))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *server) setStatus(job *serveJob, status string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	job.Status = status
+	if err != nil {
+		job.Status = "failed"
+		job.Error = err.Error()
+	}
+
+	if job.Status == "done" || job.Status == "failed" {
+		job.Finished = time.Now()
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{message})
+}
+
+// handleDownloads lists the queued downloads on GET, and queues the url in a
+// {"url": "..."} body on POST.
+func (s *server) handleDownloads(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		writeJSON(w, http.StatusOK, s.jobs)
+
+	case http.MethodPost:
+		var body struct {
+			URL string `json:"url"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.URL == "" {
+			writeJSONError(w, http.StatusBadRequest, "expected a JSON body with a url")
+			return
+		}
+
+		if _, err := crunchyroll.IsSeries(body.URL); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		s.lock.Lock()
+		job := &serveJob{ID: len(s.jobs) + 1, URL: body.URL, Status: "queued", Queued: time.Now()}
+		select {
+		case s.queue <- job:
+			s.jobs = append(s.jobs, job)
+			s.lock.Unlock()
+			logInfo("Queued %s", job.URL)
+			writeJSON(w, http.StatusAccepted, job)
+		default:
+			s.lock.Unlock()
+			writeJSONError(w, http.StatusServiceUnavailable, "the queue is full")
+		}
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET or POST")
+	}
+}
+
+// handleLimitRate returns the rate limit on GET, and changes it to the rate in
+// a {"rate": "5M"} body on PUT.
+func (s *server) handleLimitRate(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body struct {
+			Rate string `json:"rate"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "expected a JSON body with a rate")
+			return
+		}
+
+		rate, err := hls.ParseRate(body.Rate)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		s.opts.limiter.SetRate(rate)
+		logInfo("Rate limit changed to %s", hls.FormatRate(rate))
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET or PUT")
+		return
+	}
+
+	rate := s.opts.limiter.Rate()
+	writeJSON(w, http.StatusOK, struct {
+		Rate      int64  `json:"rate"`
+		Formatted string `json:"formatted"`
+	}{rate, hls.FormatRate(rate)})
+}
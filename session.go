@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/turtletowerz/crunchyrip/crunchyroll"
+)
+
+// savedSession is what `crunchyrip login` writes to disk, so that later
+// commands can skip the username and password.
+type savedSession struct {
+	UserAgent string        `json:"user_agent"`
+	Cookies   []savedCookie `json:"cookies"`
+}
+
+type savedCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("finding config directory: %w", err)
+	}
+	return filepath.Join(dir, "crunchyrip"), nil
+}
+
+func sessionPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "session.json"), nil
+}
+
+func saveSession(session *crunchyroll.Session) (string, error) {
+	path, err := sessionPath()
+	if err != nil {
+		return "", err
+	}
+
+	var saved savedSession
+	saved.UserAgent = session.UserAgent
+	for _, cookie := range session.Cookies() {
+		saved.Cookies = append(saved.Cookies, savedCookie{cookie.Name, cookie.Value})
+	}
+
+	data, err := json.MarshalIndent(saved, "", "\t")
+	if err != nil {
+		return "", fmt.Errorf("encoding session: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("creating config directory: %w", err)
+	}
+
+	// The cookies are as good as the password, so only the user can read them
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("writing session: %w", err)
+	}
+	return path, nil
+}
+
+func loadSession(session *crunchyroll.Session) error {
+	path, err := sessionPath()
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("not logged in, run `crunchyrip login username password` or pass them before the url")
+		}
+		return fmt.Errorf("reading session: %w", err)
+	}
+
+	var saved savedSession
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("decoding session %q: %w", path, err)
+	}
+
+	cookies := make([]*http.Cookie, len(saved.Cookies))
+	for i, cookie := range saved.Cookies {
+		cookies[i] = &http.Cookie{Name: cookie.Name, Value: cookie.Value}
+	}
+
+	if saved.UserAgent != "" {
+		session.UserAgent = saved.UserAgent
+	}
+	session.SetCookies(cookies)
+	return nil
+}
+
+func removeSession() (string, error) {
+	path, err := sessionPath()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.Remove(path); err != nil && os.IsNotExist(err) == false {
+		return "", fmt.Errorf("removing session: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,42 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/turtletowerz/crunchyrip/hls"
+)
+
+// watchRateSignals lets the rate limit be changed while crunchyrip is running.
+// SIGUSR1 halves the current limit and SIGUSR2 doubles it.
+func watchRateSignals(limiter *hls.RateLimiter) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for sig := range signals {
+			rate := limiter.Rate()
+			if rate <= 0 {
+				logInfo("Ignoring %s, no rate limit is set", sig)
+				continue
+			}
+
+			if sig == syscall.SIGUSR1 {
+				rate /= 2
+			} else {
+				rate *= 2
+			}
+
+			if rate < 1 {
+				rate = 1
+			}
+
+			limiter.SetRate(rate)
+			logInfo("Rate limit changed to %s", hls.FormatRate(rate))
+		}
+	}()
+}
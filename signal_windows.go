@@ -0,0 +1,6 @@
+package main
+
+import "github.com/turtletowerz/crunchyrip/hls"
+
+// watchRateSignals does nothing on Windows, which has no user signals.
+func watchRateSignals(limiter *hls.RateLimiter) {}
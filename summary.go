@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/turtletowerz/crunchyrip/crunchyroll"
+	"github.com/turtletowerz/crunchyrip/hls"
+)
+
+// episodeOutcome is an episode in the summary of a run. For a url that failed
+// before its episodes were known, only URL and Error are set.
+type episodeOutcome struct {
+	URL   string `json:"url"`
+	Title string `json:"title,omitempty"`
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// retryEntry is a line of the failed list, which can be passed back to -batch.
+type retryEntry struct {
+	url       string
+	positions []int
+	opts      downloadOptions
+}
+
+func (r *retryEntry) String() string {
+	fields := []string{r.url}
+	if len(r.positions) > 0 {
+		sort.Ints(r.positions)
+		episodes := make([]string, len(r.positions))
+		for i, position := range r.positions {
+			episodes[i] = strconv.Itoa(position)
+		}
+		fields = append(fields, "episodes="+strings.Join(episodes, ","))
+	} else if series, _ := crunchyroll.IsSeries(r.url); series && len(r.opts.episodes) > 0 {
+		fields = append(fields, "episodes="+r.opts.episodes.String())
+	}
+
+	fields = append(fields, "quality="+r.opts.quality)
+	if r.opts.dubbed {
+		fields = append(fields, "dub=true")
+	} else {
+		fields = append(fields, "subs="+r.opts.subLang)
+	}
+
+	// Batch lines are split on whitespace, so a template with spaces has to
+	// come from the flags again
+	if r.opts.output != "" && strings.ContainsAny(r.opts.output, " \t") == false {
+		fields = append(fields, "output="+r.opts.output)
+	}
+	return strings.Join(fields, " ")
+}
+
+// summary collects the outcome of every episode of a run, including every url
+// of a batch, for the report printed at the end. It is a hls.Reporter so that
+// it can count the bytes of the finished segments. The methods of a nil
+// summary do nothing.
+type summary struct {
+	started time.Time
+	bytes   int64
+
+	lock       sync.Mutex
+	downloaded []episodeOutcome
+	skipped    []episodeOutcome
+	failed     []episodeOutcome
+	planned    []episodeOutcome
+	hookFailed []episodeOutcome
+	estimated  int64
+	retries    []*retryEntry
+}
+
+func newSummary() *summary {
+	return &summary{started: time.Now()}
+}
+
+// Report counts the bytes of each finished segment.
+func (s *summary) Report(event hls.Event) {
+	if s != nil && event.Type == hls.SegmentDone {
+		atomic.AddInt64(&s.bytes, event.Bytes)
+	}
+}
+
+func outcome(job *episodeJob) episodeOutcome {
+	return episodeOutcome{
+		URL:   job.episode.EpisodeURL,
+		Title: job.episode.Title,
+		Path:  job.filepath + job.filename,
+	}
+}
+
+func (s *summary) done(job *episodeJob) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.downloaded = append(s.downloaded, outcome(job))
+}
+
+func (s *summary) skip(job *episodeJob) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.skipped = append(s.skipped, outcome(job))
+}
+
+// plan records an episode that -dry-run would download, and its estimated
+// size.
+func (s *summary) plan(job *episodeJob, estimate int64) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.planned = append(s.planned, outcome(job))
+	s.estimated += estimate
+}
+
+// hookFail records an episode whose -exec-after command failed. The episode
+// itself still counts as downloaded.
+func (s *summary) hookFail(job *episodeJob, err error) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	failed := outcome(job)
+	failed.Error = err.Error()
+	s.hookFailed = append(s.hookFailed, failed)
+}
+
+// fail records an episode of showURL that failed. Episodes of a series are
+// retried through the series url, so that they are saved to the same place.
+func (s *summary) fail(showURL string, singleEpisode bool, job *episodeJob, err error, opts downloadOptions) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	failed := outcome(job)
+	failed.Error = err.Error()
+	s.failed = append(s.failed, failed)
+
+	if singleEpisode {
+		s.retries = append(s.retries, &retryEntry{url: showURL, opts: opts})
+		return
+	}
+
+	for _, retry := range s.retries {
+		if retry.url == showURL && len(retry.positions) > 0 {
+			retry.positions = append(retry.positions, job.position)
+			return
+		}
+	}
+	s.retries = append(s.retries, &retryEntry{url: showURL, positions: []int{job.position}, opts: opts})
+}
+
+// failURL records a url whose episodes couldn't be listed.
+func (s *summary) failURL(showURL string, err error, opts downloadOptions) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failed = append(s.failed, episodeOutcome{URL: showURL, Error: err.Error()})
+	s.retries = append(s.retries, &retryEntry{url: showURL, opts: opts})
+}
+
+// summaryRecord is written at the end of a run.
+type summaryRecord struct {
+	Type       string           `json:"type"`
+	Downloaded []episodeOutcome `json:"downloaded"`
+	Skipped    []episodeOutcome `json:"skipped"`
+	Failed     []episodeOutcome `json:"failed"`
+	Planned    []episodeOutcome `json:"planned,omitempty"`
+	HookFailed []episodeOutcome `json:"hook_failed,omitempty"`
+	Estimated  int64            `json:"estimated_bytes,omitempty"`
+	Bytes      int64            `json:"bytes"`
+	Seconds    float64          `json:"seconds"`
+	Speed      int64            `json:"speed"`
+	FailedList string           `json:"failed_list,omitempty"`
+}
+
+// print writes the summary table. If any episode failed and failedList isn't
+// empty, the failed urls are written to it as a batch file.
+func (s *summary) print(failedList string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	elapsed := time.Since(s.started)
+	bytes := atomic.LoadInt64(&s.bytes)
+
+	var speed int64
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		speed = int64(float64(bytes) / seconds)
+	}
+
+	var listErr error
+	if len(s.retries) > 0 && failedList != "" {
+		lines := make([]string, len(s.retries))
+		for i, retry := range s.retries {
+			lines[i] = retry.String()
+		}
+
+		if listErr = ioutil.WriteFile(failedList, []byte(strings.Join(lines, "\n")+"\n"), 0644); listErr != nil {
+			listErr = fmt.Errorf("writing failed list: %w", listErr)
+			failedList = ""
+		}
+	} else {
+		failedList = ""
+	}
+
+	logCyan("Summary:")
+	writeOutput("  %-11s %d", "Downloaded", len(s.downloaded))
+	writeOutput("  %-11s %d", "Skipped", len(s.skipped))
+	writeOutput("  %-11s %d", "Failed", len(s.failed))
+	if len(s.hookFailed) > 0 {
+		writeOutput("  %-11s %d", "Hook failed", len(s.hookFailed))
+	}
+	if len(s.planned) > 0 {
+		writeOutput("  %-11s %d", "Planned", len(s.planned))
+		writeOutput("  %-11s %s", "Estimated", hls.FormatBytes(s.estimated))
+	}
+	writeOutput("  %-11s %s", "Data", hls.FormatBytes(bytes))
+	writeOutput("  %-11s %s", "Time", elapsed.Round(time.Second))
+	if speed > 0 {
+		writeOutput("  %-11s %s", "Speed", hls.FormatRate(speed))
+	}
+
+	if len(s.failed) > 0 {
+		logInfo("Failed:")
+		for _, failed := range s.failed {
+			name := failed.URL
+			if failed.Title != "" {
+				name = failed.Title + " (" + failed.URL + ")"
+			}
+			writeOutput("  %s: %s", name, failed.Error)
+		}
+	}
+
+	if len(s.hookFailed) > 0 {
+		logInfo("Failed -exec-after:")
+		for _, failed := range s.hookFailed {
+			writeOutput("  %s (%s): %s", failed.Title, failed.Path, failed.Error)
+		}
+	}
+
+	if failedList != "" {
+		logInfo("Retry the failed episodes with: crunchyrip download -batch %s", failedList)
+	}
+
+	emit(summaryRecord{
+		Type:       "summary",
+		Downloaded: nonNil(s.downloaded),
+		Skipped:    nonNil(s.skipped),
+		Failed:     nonNil(s.failed),
+		Planned:    s.planned,
+		HookFailed: s.hookFailed,
+		Estimated:  s.estimated,
+		Bytes:      bytes,
+		Seconds:    elapsed.Seconds(),
+		Speed:      speed,
+		FailedList: failedList,
+	})
+	return listErr
+}
+
+// nonNil keeps empty lists from being written as null.
+func nonNil(outcomes []episodeOutcome) []episodeOutcome {
+	if outcomes == nil {
+		return []episodeOutcome{}
+	}
+	return outcomes
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestRetryEntryString(t *testing.T) {
+	const series = "https://www.crunchyroll.com/my-hero-academia"
+	const episode = "https://www.crunchyroll.com/my-hero-academia/episode-1-izuku-midoriya-origin-730015"
+
+	tests := []struct {
+		entry retryEntry
+		want  string
+	}{
+		{
+			entry: retryEntry{url: episode, opts: downloadOptions{quality: "720", subLang: "en-US"}},
+			want:  episode + " quality=720 subs=en-US",
+		},
+		{
+			entry: retryEntry{url: series, positions: []int{5, 1, 3}, opts: downloadOptions{quality: "1080", subLang: "es-MX"}},
+			want:  series + " episodes=1,3,5 quality=1080 subs=es-MX",
+		},
+		{
+			entry: retryEntry{url: series, opts: downloadOptions{quality: "max", subLang: "none", dubbed: true, episodes: episodeRanges{{1, 3}, {10, 0}}}},
+			want:  series + " episodes=1-3,10- quality=max dub=true",
+		},
+		{
+			entry: retryEntry{url: episode, opts: downloadOptions{quality: "720", subLang: "en-US", episodes: episodeRanges{{2, 2}}}},
+			want:  episode + " quality=720 subs=en-US",
+		},
+		{
+			entry: retryEntry{url: episode, opts: downloadOptions{quality: "720", subLang: "en-US", output: "{series}/{title}"}},
+			want:  episode + " quality=720 subs=en-US output={series}/{title}",
+		},
+		{
+			entry: retryEntry{url: episode, opts: downloadOptions{quality: "720", subLang: "en-US", output: "{series} - {title}"}},
+			want:  episode + " quality=720 subs=en-US",
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.entry.String(); got != test.want {
+			t.Errorf("retryEntry{%q}.String() = %q, want %q", test.entry.url, got, test.want)
+		}
+	}
+}